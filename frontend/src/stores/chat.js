@@ -4,13 +4,27 @@ import { ref, shallowRef } from 'vue'
 export const useChatStore = defineStore('chat', () => {
   const messages = ref([])
   const isConnected = ref(false)
+  const status = ref('active') // 'active' | 'paused', reported by the server
+  const summary = ref('') // Rolling summary of the older turns, if enabled
+  const verdict = ref(null) // The judge's structured verdict, once judged
+  const ballots = ref([]) // Individual panel ballots, as they arrive
+  const phase = ref('') // Current debate format phase, if any
   const socket = shallowRef(null)
 
+  // Where to resume after an unexpected disconnect: the seq of the last
+  // event seen on this url. Reset by an intentional disconnect.
+  let reconnect = { url: '', config: null, seq: 0, retries: 0 }
+
   function connect(url, config) {
     if (socket.value) return
 
+    if (reconnect.url !== url) {
+      reconnect = { url, config, seq: 0, retries: 0 }
+    }
+    const target = reconnect.seq ? `${url}&since=${reconnect.seq}` : url
+
     // Connect to Backend WS
-    socket.value = new WebSocket(url)
+    socket.value = new WebSocket(target)
 
     socket.value.onopen = () => {
       isConnected.value = true
@@ -22,10 +36,20 @@ export const useChatStore = defineStore('chat', () => {
     socket.value.onmessage = (event) => {
       try {
         const msg = JSON.parse(event.data)
-        
-        if (msg.type === 'start') {
+        if (msg.seq) {
+          reconnect.seq = msg.seq
+          reconnect.retries = 0
+        }
+
+        if (msg.type === 'snapshot') {
+          // We missed too much to replay: start over from the server's state.
+          const snap = JSON.parse(msg.content)
+          messages.value = snap.partial ? [...snap.history, snap.partial] : snap.history
+          reconnect.seq = snap.seq
+        } else if (msg.type === 'start') {
           // New message bubble start
           messages.value.push({
+            id: msg.id,
             sender: msg.sender,
             content: '', // Will be filled by chunks
             type: msg.type
@@ -41,6 +65,27 @@ export const useChatStore = defineStore('chat', () => {
           // Finished turn, maybe mark as done?
         } else if (msg.type === 'system') {
            messages.value.push(msg)
+        } else if (msg.type === 'status') {
+           status.value = msg.content
+        } else if (msg.type === 'summary') {
+           summary.value = msg.content
+        } else if (msg.type === 'phase') {
+           phase.value = msg.content
+           messages.value.push({ sender: 'System', content: 'Phase: ' + msg.content, type: 'system' })
+        } else if (msg.type === 'ballot') {
+           ballots.value.push(JSON.parse(msg.content))
+        } else if (msg.type === 'verdict') {
+           verdict.value = JSON.parse(msg.content)
+        } else if (msg.type === 'error') {
+           // { kind, message, attempts }: kind is e.g. rate_limit, auth, context_length, server
+           const err = JSON.parse(msg.content)
+           const label = err.kind ? `[${err.kind}] ` : ''
+           messages.value.push({ sender: msg.sender, content: label + err.message, type: 'error' })
+        } else if (msg.type === 'edit') {
+           const target = messages.value.find(m => m.id === msg.id)
+           if (target) target.content = msg.content
+        } else if (msg.type === 'delete') {
+           messages.value = messages.value.filter(m => m.id !== msg.id)
         } else if (msg.type === 'cmd') {
             if (msg.content === 'stop') {
                 console.log('Received stop command from server')
@@ -57,14 +102,24 @@ export const useChatStore = defineStore('chat', () => {
       }
     }
 
-    socket.value.onclose = () => {
+    const ws = socket.value
+    ws.onclose = () => {
+      if (socket.value !== ws) return // Closed on purpose
       isConnected.value = false
       socket.value = null
       console.log('Disconnected from Chat WS')
+      // Reconnect and pick up where we left off, backing off a little each time.
+      if (reconnect.url === url && reconnect.retries < 5) {
+        const delay = 1000 * 2 ** reconnect.retries++
+        setTimeout(() => {
+          if (reconnect.url === url) connect(url, reconnect.config)
+        }, delay)
+      }
     }
   }
 
   function disconnect() {
+    reconnect = { url: '', config: null, seq: 0, retries: 0 }
     if (socket.value) {
       socket.value.close()
       socket.value = null
@@ -99,5 +154,27 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
-  return { messages, isConnected, connect, disconnect, sendMessage, conclude }
+  function sendCommand(content) {
+    if (socket.value && socket.value.readyState === WebSocket.OPEN) {
+        socket.value.send(JSON.stringify({ sender: 'User', type: 'cmd', content }))
+    } else {
+        console.warn(`Cannot send ${content}, socket not open`)
+        isConnected.value = false
+    }
+  }
+
+  // immediate cuts the current speaker off instead of letting it finish
+  const pause = (immediate = false) => sendCommand(immediate ? 'pause_now' : 'pause')
+  const resume = () => sendCommand('resume')
+  const regenerate = () => sendCommand('regenerate')
+
+  function sendEdit(type, id, content = '') {
+    if (socket.value && socket.value.readyState === WebSocket.OPEN) {
+        socket.value.send(JSON.stringify({ sender: 'User', type, id, content }))
+    }
+  }
+  const editMessage = (id, content) => sendEdit('edit', id, content)
+  const deleteMessage = (id) => sendEdit('delete', id)
+
+  return { messages, isConnected, status, summary, verdict, ballots, phase, connect, disconnect, sendMessage, conclude, pause, resume, regenerate, editMessage, deleteMessage }
 })
@@ -1,19 +1,31 @@
 package agent
 
 import (
+	"context"
+	"errors"
 	"log"
 	"qigent/internal/llm"
 )
 
+// ErrNoClient is returned when an agent has no LLM client configured.
+var ErrNoClient = errors.New("agent has no LLM client")
+
 // Agent represents an entity that can participate in a conversation.
 type Agent struct {
 	Name         string
 	SystemPrompt string
-	LLMClient    *llm.Client
+	LLMClient    llm.Provider
+	// NameStyle controls how other speakers' names are shown to the model.
+	NameStyle llm.NameStyle
+
+	// Scratchpad lets the agent write private notes (see NoteFilter).
+	// Notes are shown to this agent only.
+	Scratchpad bool
+	Notes      []Note
 }
 
 // NewAgent creates a new Agent instance.
-func NewAgent(name, systemPrompt string, client *llm.Client) *Agent {
+func NewAgent(name, systemPrompt string, client llm.Provider) *Agent {
 	return &Agent{
 		Name:         name,
 		SystemPrompt: systemPrompt,
@@ -21,16 +33,24 @@ func NewAgent(name, systemPrompt string, client *llm.Client) *Agent {
 	}
 }
 
-// SpeakStream calls the LLM using streaming and returns a channel of chunks.
-func (a *Agent) SpeakStream(history []string) (<-chan string, error) {
+// Messages maps the shared transcript onto chat roles from this agent's
+// point of view: its own turns are "assistant", everyone else's "user".
+// With a client, older turns are dropped to fit the model's context window.
+func (a *Agent) Messages(history []llm.Turn) []llm.ChatMessage {
 	if a.LLMClient == nil {
-		return nil, nil // Should handle error better
+		return llm.BuildMessages(a.systemPrompt(), a.Name, history, a.NameStyle)
 	}
+	return a.LLMClient.Messages(a.systemPrompt(), a.Name, history, a.NameStyle)
+}
 
-	// Add "[Agent Name]: " prefix to history if not present?
-	// The current history format in Room is "Sender: Content".
+// SpeakStream calls the LLM using streaming and returns the stream of chunks.
+// The stream is torn down (and its channel closed) when ctx is cancelled.
+func (a *Agent) SpeakStream(ctx context.Context, history []llm.Turn) (*llm.Stream, error) {
+	if a.LLMClient == nil {
+		return nil, ErrNoClient
+	}
 
-	stream, err := a.LLMClient.ChatStream(a.SystemPrompt, history)
+	stream, err := a.LLMClient.ChatStream(ctx, a.Messages(history))
 	if err != nil {
 		log.Printf("Agent %s LLM error: %v", a.Name, err)
 		return nil, err
@@ -38,3 +58,11 @@ func (a *Agent) SpeakStream(history []string) (<-chan string, error) {
 
 	return stream, nil
 }
+
+// Speak calls the LLM and waits for the complete reply.
+func (a *Agent) Speak(ctx context.Context, history []llm.Turn) (string, error) {
+	if a.LLMClient == nil {
+		return "", ErrNoClient
+	}
+	return a.LLMClient.Chat(ctx, a.Messages(history))
+}
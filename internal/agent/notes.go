@@ -0,0 +1,165 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Note tags mark private output: text between them is kept in the agent's
+// notes and never shown to the other participants or the audience.
+const (
+	NoteOpen  = "<note>"
+	NoteClose = "</note>"
+)
+
+// MaxNotes is how many notes an agent keeps; older ones are forgotten first.
+const MaxNotes = 30
+
+const noteInstruction = "You have a private scratchpad. Anything you wrap in " + NoteOpen + "..." + NoteClose +
+	" is hidden from everyone else and shown back to you in later turns. Use it for strategy, commitments and facts you must stay consistent with."
+
+// Note is one private note, tagged with the turn that wrote it.
+type Note struct {
+	Text   string `json:"text"`
+	TurnID string `json:"turnId,omitempty"`
+}
+
+// NoteFilter returns a filter for one streamed reply, or nil (pass-through)
+// if the agent has no scratchpad.
+func (a *Agent) NoteFilter() *NoteFilter {
+	if !a.Scratchpad {
+		return nil
+	}
+	return &NoteFilter{}
+}
+
+// Remember appends notes written during turnID, dropping the oldest past MaxNotes.
+func (a *Agent) Remember(turnID string, texts ...string) {
+	for _, t := range texts {
+		a.Notes = append(a.Notes, Note{Text: t, TurnID: turnID})
+	}
+	if len(a.Notes) > MaxNotes {
+		a.Notes = a.Notes[len(a.Notes)-MaxNotes:]
+	}
+}
+
+// Forget drops the notes written during turnID (e.g. a regenerated turn).
+func (a *Agent) Forget(turnID string) {
+	a.Notes = ForgetTurn(a.Notes, turnID)
+}
+
+// ForgetTurn filters out of notes, in place, those written during turnID.
+func ForgetTurn(notes []Note, turnID string) []Note {
+	kept := notes[:0]
+	for _, n := range notes {
+		if n.TurnID != turnID {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// systemPrompt is the agent's prompt plus, with a scratchpad, the note
+// instructions and its notes so far. Only this agent ever sees them.
+func (a *Agent) systemPrompt() string {
+	if !a.Scratchpad {
+		return a.SystemPrompt
+	}
+	var sb strings.Builder
+	sb.WriteString(a.SystemPrompt)
+	sb.WriteString("\n\n")
+	sb.WriteString(noteInstruction)
+	if len(a.Notes) > 0 {
+		sb.WriteString("\n\nYour private notes so far:")
+		for i, n := range a.Notes {
+			fmt.Fprintf(&sb, "\n%d. %s", i+1, n.Text)
+		}
+	}
+	return sb.String()
+}
+
+// NoteFilter separates notes from the public part of a streamed reply.
+// Tags may be split across chunks; a possible partial tag is held back
+// until the next chunk decides it. A nil filter passes everything through.
+type NoteFilter struct {
+	pending string // Undecided text: a possible partial tag
+	inNote  bool
+	note    strings.Builder
+	notes   []string
+}
+
+// Feed consumes a chunk and returns the part that may be shown publicly.
+func (f *NoteFilter) Feed(chunk string) string {
+	if f == nil {
+		return chunk
+	}
+	text := f.pending + chunk
+	f.pending = ""
+
+	var visible strings.Builder
+	for text != "" {
+		tag := NoteOpen
+		if f.inNote {
+			tag = NoteClose
+		}
+
+		if i := strings.Index(text, tag); i >= 0 {
+			f.write(&visible, text[:i])
+			text = text[i+len(tag):]
+			if f.inNote {
+				f.endNote()
+			}
+			f.inNote = !f.inNote
+			continue
+		}
+
+		// Hold back a tail that could be the start of the tag.
+		keep := partialSuffix(text, tag)
+		f.write(&visible, text[:len(text)-keep])
+		f.pending = text[len(text)-keep:]
+		break
+	}
+	return visible.String()
+}
+
+// Close ends the reply. It returns any held-back public text and the
+// notes found; an unterminated note still counts.
+func (f *NoteFilter) Close() (string, []string) {
+	if f == nil {
+		return "", nil
+	}
+	var visible strings.Builder
+	f.write(&visible, f.pending)
+	f.pending = ""
+	if f.inNote {
+		f.endNote()
+		f.inNote = false
+	}
+	return visible.String(), f.notes
+}
+
+func (f *NoteFilter) write(visible *strings.Builder, s string) {
+	if f.inNote {
+		f.note.WriteString(s)
+	} else {
+		visible.WriteString(s)
+	}
+}
+
+func (f *NoteFilter) endNote() {
+	if t := strings.TrimSpace(f.note.String()); t != "" {
+		f.notes = append(f.notes, t)
+	}
+	f.note.Reset()
+}
+
+// partialSuffix returns the length of the longest suffix of s that is a
+// proper prefix of tag.
+func partialSuffix(s, tag string) int {
+	for n := min(len(tag)-1, len(s)); n > 0; n-- {
+		if strings.HasSuffix(s, tag[:n]) {
+			return n
+		}
+	}
+	return 0
+}
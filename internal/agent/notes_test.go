@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNoteFilterSplitTags(t *testing.T) {
+	chunks := []string{"I agree", " <no", "te>concede ", "later</n", "ote> for now.", " <note>unfinished"}
+
+	var f NoteFilter
+	var visible strings.Builder
+	for _, c := range chunks {
+		visible.WriteString(f.Feed(c))
+	}
+	tail, notes := f.Close()
+	visible.WriteString(tail)
+
+	if got := visible.String(); got != "I agree  for now. " {
+		t.Fatalf("visible = %q", got)
+	}
+	if len(notes) != 2 || notes[0] != "concede later" || notes[1] != "unfinished" {
+		t.Fatalf("notes = %q", notes)
+	}
+}
+
+func TestNoteFilterHoldsBackPartialTag(t *testing.T) {
+	var f NoteFilter
+	if got := f.Feed("a <"); got != "a " {
+		t.Fatalf("Feed = %q, want the '<' held back", got)
+	}
+	if got := f.Feed("b"); got != "<b" {
+		t.Fatalf("Feed = %q, want released text", got)
+	}
+}
+
+func TestRememberAndForget(t *testing.T) {
+	a := NewAgent("A", "prompt", nil)
+	a.Scratchpad = true
+	a.Remember("t1", "plan")
+	a.Remember("t2", "retract")
+	a.Forget("t2")
+
+	if len(a.Notes) != 1 || a.Notes[0].Text != "plan" {
+		t.Fatalf("notes = %+v", a.Notes)
+	}
+	if !strings.Contains(a.systemPrompt(), "1. plan") {
+		t.Fatalf("system prompt lacks notes: %q", a.systemPrompt())
+	}
+}
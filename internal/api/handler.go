@@ -1,12 +1,17 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"qigent/internal/agent"
 	"qigent/internal/chat"
 	"qigent/internal/data"
 	"qigent/internal/llm"
+	"qigent/internal/scenario"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
@@ -24,24 +29,62 @@ func CreateConversation(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
 
 	var req struct {
-		Topic  string           `json:"topic"`
-		AgentA data.AgentConfig `json:"agentA"`
-		AgentB data.AgentConfig `json:"agentB"`
+		Topic        string             `json:"topic"`
+		Agents       []data.AgentConfig `json:"agents"`
+		TurnPolicy   string             `json:"turnPolicy"`
+		Moderator    *data.AgentConfig  `json:"moderator"`
+		StopRules    chat.StopRules     `json:"stopRules"`
+		OnLastLeave  string             `json:"onLastLeave"`
+		Model        string             `json:"model"`
+		NameStyle    llm.NameStyle      `json:"nameStyle"`
+		SummaryEvery int                `json:"summaryEvery"`
+		Scratchpad   bool               `json:"scratchpad"`
+		Judge        chat.JudgeConfig   `json:"judge"`
+		Format       *chat.Format       `json:"format"`
+		// Legacy two-agent payload, still sent by older clients.
+		AgentA *data.AgentConfig `json:"agentA"`
+		AgentB *data.AgentConfig `json:"agentB"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(400, gin.H{"error": "Invalid request"})
 		return
 	}
 
+	agents := req.Agents
+	if len(agents) == 0 {
+		for _, a := range []*data.AgentConfig{req.AgentA, req.AgentB} {
+			if a != nil {
+				agents = append(agents, *a)
+			}
+		}
+	}
+
 	conv := &data.Conversation{
-		ID:        chat.NewID(),
-		UserID:    userID,
-		Topic:     req.Topic,
-		Status:    "active",
-		AgentA:    req.AgentA,
-		AgentB:    req.AgentB,
-		History:   []chat.Message{},
-		CreatedAt: chat.Now(),
+		ID:           chat.NewID(),
+		UserID:       userID,
+		Topic:        req.Topic,
+		Status:       "active",
+		Agents:       agents,
+		History:      []chat.Message{},
+		TurnPolicy:   req.TurnPolicy,
+		Moderator:    req.Moderator,
+		StopRules:    req.StopRules,
+		OnLastLeave:  req.OnLastLeave,
+		Model:        req.Model,
+		NameStyle:    req.NameStyle,
+		SummaryEvery: req.SummaryEvery,
+		Scratchpad:   req.Scratchpad,
+		Judge:        req.Judge,
+		Format:       req.Format,
+		CreatedAt:    chat.Now(),
+	}
+	if err := conv.Resolve(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := conv.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
 	}
 
 	if err := data.CreateConversation(conv); err != nil {
@@ -51,9 +94,11 @@ func CreateConversation(c *gin.Context) {
 	c.JSON(200, conv)
 }
 
+// GetConversations lists the user's conversations; ?winner=<name> narrows
+// them to those the judge awarded to that participant (or "draw").
 func GetConversations(c *gin.Context) {
 	userID := c.MustGet("userID").(uint)
-	convs, err := data.GetConversations(userID)
+	convs, err := data.GetConversations(userID, c.Query("winner"))
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -78,9 +123,371 @@ func GetConversation(c *gin.Context) {
 	c.JSON(200, conv)
 }
 
+// UpdateConversationAgents replaces the ordered participant list of a conversation.
+// Takes effect the next time a room is started for it, so it is refused
+// while a room is running: the room would overwrite it on its next save.
+func UpdateConversationAgents(c *gin.Context) {
+	id := c.Param("id")
+	userID := c.MustGet("userID").(uint)
+
+	var req struct {
+		Agents []data.AgentConfig `json:"agents"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	if err := data.ValidateAgents(req.Agents); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	conv, err := data.GetConversation(id)
+	if err != nil || conv == nil {
+		c.JSON(404, gin.H{"error": "Not found"})
+		return
+	}
+	if conv.UserID != userID {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return
+	}
+	if chat.DefaultHub.Get(id) != nil {
+		c.JSON(409, gin.H{"error": "Conversation is running; stop it first"})
+		return
+	}
+	if err := data.ValidateModerator(conv.Moderator, req.Agents); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := data.ValidateFormat(conv.Format, req.Agents); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := data.ResolveAgents(req.Agents, userID); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	conv.Agents = req.Agents
+	if err := data.SaveConversation(conv); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, conv)
+}
+
+// ForkConversation creates a new conversation from an existing one, keeping
+// the first atIndex history messages. Agents, topic and model can be swapped,
+// and an optional user intervention is appended at the branch point.
+func ForkConversation(c *gin.Context) {
+	id := c.Param("id")
+	userID := c.MustGet("userID").(uint)
+
+	var req struct {
+		AtIndex      int                `json:"atIndex"`
+		Agents       []data.AgentConfig `json:"agents"`
+		Topic        string             `json:"topic"`
+		Model        string             `json:"model"`
+		Intervention string             `json:"intervention"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+
+	parent, err := data.GetConversation(id)
+	if err != nil || parent == nil {
+		c.JSON(404, gin.H{"error": "Not found"})
+		return
+	}
+	if parent.UserID != userID {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	fork, err := parent.Fork(chat.NewID(), req.AtIndex)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Agents) > 0 {
+		if err := data.ValidateAgents(req.Agents); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := data.ValidateModerator(fork.Moderator, req.Agents); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := data.ValidateFormat(fork.Format, req.Agents); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := data.ResolveAgents(req.Agents, userID); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		fork.Agents = req.Agents
+	}
+	if req.Topic != "" {
+		fork.Topic = req.Topic
+	}
+	if req.Model != "" {
+		fork.Model = req.Model
+	}
+	if req.Intervention != "" {
+		fork.History = append(fork.History, chat.Message{
+			Sender:  "User",
+			Content: req.Intervention,
+			Type:    chat.EventMessage,
+		})
+	}
+
+	if err := data.CreateConversation(fork); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, fork)
+}
+
+// GetForks lists the direct forks of a conversation, so clients can walk the branch tree.
+func GetForks(c *gin.Context) {
+	id := c.Param("id")
+	userID := c.MustGet("userID").(uint)
+	forks, err := data.GetForks(id, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, forks)
+}
+
+// RegenerateMessage drops the last agent turn so the same agent speaks again.
+func RegenerateMessage(c *gin.Context) {
+	editHistory(c, func(ctx context.Context, room *chat.Room) error {
+		return room.Regenerate(ctx)
+	}, func(conv *data.Conversation) error {
+		if conv.Status == "finished" {
+			return chat.ErrRoomStopped // It won't run again to take the turn
+		}
+		names := make([]string, len(conv.Agents))
+		for i, a := range conv.Agents {
+			names[i] = a.Name
+		}
+		p := conv.Progress()
+		if _, err := p.Regenerate(names); err != nil {
+			return err
+		}
+		conv.SetProgress(p)
+		return nil
+	})
+}
+
+// EditMessage replaces the text of a stored message. Body: {"content": "..."}.
+func EditMessage(c *gin.Context) {
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request"})
+		return
+	}
+	msgID := c.Param("msgId")
+	editHistory(c, func(ctx context.Context, room *chat.Room) error {
+		return room.EditMessage(ctx, msgID, req.Content)
+	}, func(conv *data.Conversation) error {
+		var err error
+		conv.History, err = chat.EditMessage(conv.History, msgID, req.Content)
+		return err
+	})
+}
+
+// DeleteMessage removes a message from a conversation's history.
+func DeleteMessage(c *gin.Context) {
+	msgID := c.Param("msgId")
+	editHistory(c, func(ctx context.Context, room *chat.Room) error {
+		return room.DeleteMessage(ctx, msgID)
+	}, func(conv *data.Conversation) error {
+		p := conv.Progress()
+		if err := p.Delete(msgID); err != nil {
+			return err
+		}
+		conv.SetProgress(p)
+		return nil
+	})
+}
+
+// editHistory routes a history change to the running room if there is one
+// (the room persists it via the hub session), or applies it to the stored
+// conversation directly.
+func editHistory(c *gin.Context, live func(context.Context, *chat.Room) error, stored func(*data.Conversation) error) {
+	id := c.Param("id")
+	userID := c.MustGet("userID").(uint)
+
+	conv, err := data.GetConversation(id)
+	if err != nil || conv == nil {
+		c.JSON(404, gin.H{"error": "Not found"})
+		return
+	}
+	if conv.UserID != userID {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return
+	}
+
+	session := chat.DefaultHub.Get(id)
+	if session != nil {
+		err = live(c.Request.Context(), session.Room())
+	}
+	if session == nil || errors.Is(err, chat.ErrRoomStopped) && chat.DefaultHub.Get(id) != session {
+		// Not running (or stopped meanwhile): edit the stored copy. A room
+		// still open after its conversation ended refuses on its own.
+		err = stored(conv)
+		if err == nil {
+			err = data.SaveConversation(conv)
+		}
+	}
+
+	if errors.Is(err, chat.ErrMessageNotFound) {
+		c.JSON(404, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, chat.ErrRoomStopped) {
+		c.JSON(409, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"status": "ok"})
+}
+
+// PauseConversation pauses a conversation. Body: {"immediate": bool}; when
+// true the current speaker is cut off, otherwise it finishes its turn.
+func PauseConversation(c *gin.Context) {
+	var req struct {
+		Immediate bool `json:"immediate"`
+	}
+	// Body is optional
+	c.ShouldBindJSON(&req)
+	setPaused(c, true, req.Immediate)
+}
+
+// ResumeConversation resumes a paused conversation.
+func ResumeConversation(c *gin.Context) {
+	setPaused(c, false, false)
+}
+
+// StopConversation stops the conversation's running room, even one set to
+// keep running with nobody watching. The conversation is left paused, so
+// the next connection starts it paused.
+func StopConversation(c *gin.Context) {
+	id := c.Param("id")
+	userID := c.MustGet("userID").(uint)
+	conv, err := data.GetConversation(id)
+	if err != nil || conv == nil {
+		c.JSON(404, gin.H{"error": "Not found"})
+		return
+	}
+	if conv.UserID != userID {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return
+	}
+	if !chat.DefaultHub.Stop(id, "paused") {
+		c.JSON(409, gin.H{"error": "Conversation is not running"})
+		return
+	}
+	c.JSON(200, gin.H{"status": "stopped"})
+}
+
+// ConcludeConversation asks the running room's judge for a verdict, which
+// ends the conversation. It returns at once; the verdict arrives as events
+// and is saved by the session.
+func ConcludeConversation(c *gin.Context) {
+	id := c.Param("id")
+	userID := c.MustGet("userID").(uint)
+	conv, err := data.GetConversation(id)
+	if err != nil || conv == nil {
+		c.JSON(404, gin.H{"error": "Not found"})
+		return
+	}
+	if conv.UserID != userID {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return
+	}
+	session := chat.DefaultHub.Get(id)
+	if session == nil {
+		c.JSON(409, gin.H{"error": "Conversation is not running"})
+		return
+	}
+	go func() {
+		if err := session.Room().Judge(session.Context()); err != nil {
+			log.Printf("Judge: %v", err)
+		}
+	}()
+	c.JSON(202, gin.H{"status": "judging"})
+}
+
+// setPaused applies pause/resume to the running room if there is one.
+// The room reports the new status itself, which the hub session persists;
+// otherwise the status is written directly so the next connection honours it.
+func setPaused(c *gin.Context, pause, immediate bool) {
+	id := c.Param("id")
+	userID := c.MustGet("userID").(uint)
+
+	conv, err := data.GetConversation(id)
+	if err != nil || conv == nil {
+		c.JSON(404, gin.H{"error": "Not found"})
+		return
+	}
+	if conv.UserID != userID {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return
+	}
+	if conv.Status == "finished" {
+		c.JSON(409, gin.H{"error": "Conversation is finished"})
+		return
+	}
+
+	status := "active"
+	if pause {
+		status = "paused"
+	}
+
+	if session := chat.DefaultHub.Get(id); session != nil {
+		if pause {
+			session.Room().Pause(immediate)
+		} else {
+			session.Room().Resume()
+		}
+		c.JSON(200, gin.H{"status": status, "running": true})
+		return
+	}
+
+	conv.Status = status
+	if err := data.SaveConversation(conv); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"status": status, "running": false})
+}
+
+// DeleteConversation stops the conversation's room, if running, before
+// deleting it; otherwise the room's next save would bring the row back.
 func DeleteConversation(c *gin.Context) {
 	id := c.Param("id")
 	userID := c.MustGet("userID").(uint)
+	conv, err := data.GetConversation(id)
+	if err != nil || conv == nil {
+		c.JSON(404, gin.H{"error": "Not found"})
+		return
+	}
+	if conv.UserID != userID {
+		c.JSON(403, gin.H{"error": "Forbidden"})
+		return
+	}
+	chat.DefaultHub.Stop(id, "")
 	if err := data.DeleteConversation(id, userID); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -108,6 +515,11 @@ func CreateRole(c *gin.Context) {
 		return
 	}
 	role.UserID = userID
+	// The provider isn't known until the role is played; check ranges only.
+	if err := role.Sampling.Validate(llm.ProviderOpenAI); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
 	if err := data.AddRole(&role); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -125,13 +537,131 @@ func DeleteRole(c *gin.Context) {
 	c.JSON(200, gin.H{"status": "deleted"})
 }
 
+// Provider Profile Routes
+
+func GetProfiles(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	profiles, err := data.GetProfiles(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, profiles)
+}
+
+// SaveProfile creates or replaces a provider profile, by name.
+func SaveProfile(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+	var p data.Profile
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid json"})
+		return
+	}
+	if p.Name == "" {
+		c.JSON(400, gin.H{"error": "Profile name is required"})
+		return
+	}
+	if !llm.ValidProvider(p.Provider) {
+		c.JSON(400, gin.H{"error": "Unknown provider: " + p.Provider})
+		return
+	}
+	if err := data.SaveProfile(userID, &p); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, p)
+}
+
+func DeleteProfile(c *gin.Context) {
+	name := c.Param("name")
+	userID := c.MustGet("userID").(uint)
+	if err := data.DeleteProfile(name, userID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"status": "deleted"})
+}
+
+// Scenario Routes
+
+// ScenarioDir is where scenario files are read from. Files are re-read on
+// every request, so a git pull takes effect without a restart.
+var ScenarioDir = "scenarios"
+
+// GetScenarios lists the valid scenarios, plus any files that failed to load.
+func GetScenarios(c *gin.Context) {
+	scenarios, problems, err := scenario.LoadDir(ScenarioDir)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if scenarios == nil {
+		scenarios = []*scenario.Scenario{}
+	}
+	c.JSON(200, gin.H{"scenarios": scenarios, "errors": problems})
+}
+
+// StartScenario creates a conversation from a scenario. The optional topic
+// overrides the scenario's; the room starts once a client connects.
+func StartScenario(c *gin.Context) {
+	userID := c.MustGet("userID").(uint)
+
+	var req struct {
+		Topic string `json:"topic"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid request"})
+			return
+		}
+	}
+
+	s, err := scenario.Find(ScenarioDir, c.Param("name"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if s == nil {
+		c.JSON(404, gin.H{"error": "Scenario not found"})
+		return
+	}
+
+	conv := s.Conversation(userID, req.Topic)
+	if conv.Topic == "" {
+		c.JSON(400, gin.H{"error": "scenario has no topic; pass one"})
+		return
+	}
+	if err := conv.Resolve(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := conv.Validate(); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := data.CreateConversation(conv); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, conv)
+}
+
 // WebSocket Chat Handler
+//
+// Rooms live in chat.DefaultHub, keyed by conversation ID, so several
+// connections (e.g. two browser tabs) share one running loop. Connecting
+// with ?spectate=true attaches read-only to an already running room and
+// skips the API key handshake.
 func HandleChat(c *gin.Context) {
 	// Auth middleware should have set userID, BUT for WS, headers might be tricky.
 	// We expect token in Query Param for WS connection, which AuthMiddleware handles.
 	userID := c.MustGet("userID").(uint)
 
 	conversationID := c.Query("conversationId")
+	spectate := c.Query("spectate") == "true"
+	// since is the seq of the last event a reconnecting client saw.
+	since, _ := strconv.ParseUint(c.Query("since"), 10, 64)
 
 	ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -156,76 +686,278 @@ func HandleChat(c *gin.Context) {
 		return
 	}
 
-	// Handshake
-	var handshake struct {
-		APIKey  string `json:"apiKey"`
-		BaseURL string `json:"baseUrl"`
-		Model   string `json:"model"`
-	}
-	if err := ws.ReadJSON(&handshake); err != nil {
-		return
-	}
-
-	// Create Client
-	llmCfg := llm.Config{
-		BaseURL: handshake.BaseURL,
-		APIKey:  handshake.APIKey,
-		Model:   handshake.Model,
-	}
-	client := llm.NewClient(llmCfg)
-
-	agentA := agent.NewAgent(conv.AgentA.Name, conv.AgentA.Prompt, client)
-	agentB := agent.NewAgent(conv.AgentB.Name, conv.AgentB.Prompt, client)
+	var cfg *chat.SessionConfig
+	if !spectate {
+		// Handshake
+		var handshake struct {
+			Provider      string `json:"provider"`
+			APIKey        string `json:"apiKey"`
+			BaseURL       string `json:"baseUrl"`
+			Model         string `json:"model"`
+			ContextWindow int    `json:"contextWindow"`
+		}
+		if err := ws.ReadJSON(&handshake); err != nil {
+			return
+		}
 
-	room := chat.NewRoom([]*agent.Agent{agentA, agentB})
-	room.History = conv.History
+		// Create Client
+		llmCfg := llm.Config{
+			Provider: handshake.Provider,
+			BaseURL:  handshake.BaseURL,
+			APIKey:   handshake.APIKey,
+			Model:    handshake.Model,
 
-	if len(conv.History) == 0 {
-		room.StartLoop(conv.Topic)
-	} else {
-		room.StartLoop("")
+			ContextWindow: handshake.ContextWindow,
+		}
+		if conv.Model != "" {
+			llmCfg.Model = conv.Model
+		}
+		// Only used if no room is running yet for this conversation.
+		cfg = &chat.SessionConfig{
+			Build: func() (*chat.Room, string, error) {
+				return buildRoom(conv, llmCfg)
+			},
+			// The hub calls these on one goroutine at a time, so conv needs no lock.
+			OnEvent: func(room *chat.Room, ev chat.Event) {
+				if ev.Type == chat.EventStatus {
+					conv.Status = ev.Content
+				}
+				switch ev.Type {
+				case chat.EventMessage, chat.EventIntervention, chat.EventStatus, chat.EventEdit, chat.EventDelete,
+					chat.EventSummary, chat.EventPhase, chat.EventVerdict:
+					snap, err := room.Snapshot(context.Background())
+					if err != nil {
+						log.Printf("Snapshot for saving failed: %v", err)
+						return
+					}
+					syncConversation(conv, snap)
+					data.SaveConversation(conv)
+				}
+			},
+			OnClose: func(room *chat.Room, status string) {
+				// The room has exited, so this reads its final state.
+				snap, _ := room.Snapshot(context.Background())
+				syncConversation(conv, snap)
+				if status != "" {
+					conv.Status = status
+				}
+				data.SaveConversation(conv)
+			},
+			OnLastLeave: conv.OnLastLeave,
+		}
 	}
 
-	defer func() {
-		room.StopLoop()
-		conv.History = room.History
-		data.SaveConversation(conv)
-	}()
+	session, viewer, err := chat.DefaultHub.Attach(conv.ID, cfg, since)
+	if err != nil {
+		ws.WriteJSON(gin.H{"error": err.Error()})
+		return
+	}
+	defer session.Detach(viewer)
 
-	ws.WriteJSON(chat.Message{Sender: "System", Content: "Connected: " + conv.Topic, Type: "system"})
+	ws.WriteJSON(chat.Message{Sender: "System", Content: "Connected: " + conv.Topic, Type: chat.EventSystem})
 
 	// Reader Loop
 	go func() {
-		defer room.StopLoop()
+		defer session.Detach(viewer)
 		for {
 			var msg chat.Message
 			if err := ws.ReadJSON(&msg); err != nil {
 				return
 			}
-			if msg.Type == "cmd" && msg.Content == "conclude" {
+			if !viewer.Owner {
+				continue // Spectators are read-only
+			}
+			room := session.Room()
+			if msg.Type == chat.EventCommand && msg.Content == "conclude" {
 				log.Println("Received conclude command, starting Judge...")
-				if len(room.Agents) > 0 {
-					go room.Judge(room.Agents[0].LLMClient)
-				}
+				go func() {
+					if err := room.Judge(session.Context()); err != nil {
+						log.Printf("Judge: %v", err)
+					}
+				}()
+			} else if msg.Type == chat.EventCommand && (msg.Content == "pause" || msg.Content == "pause_now") {
+				// "pause" lets the current speaker finish; "pause_now" cuts it off.
+				room.Pause(msg.Content == "pause_now")
+			} else if msg.Type == chat.EventCommand && msg.Content == "resume" {
+				room.Resume()
+			} else if msg.Type == chat.EventCommand && msg.Content == "regenerate" {
+				go reportHistoryError(session, viewer, func() error { return room.Regenerate(session.Context()) })
+			} else if msg.Type == chat.EventEdit {
+				go reportHistoryError(session, viewer, func() error { return room.EditMessage(session.Context(), msg.ID, msg.Content) })
+			} else if msg.Type == chat.EventDelete {
+				go reportHistoryError(session, viewer, func() error { return room.DeleteMessage(session.Context(), msg.ID) })
 			} else if msg.Sender == "User" {
 				room.InjectMessage(msg)
 			}
 		}
 	}()
 
-	// Writer Loop
-	for {
-		msg, ok := <-room.Broadcast
+	// Writer Loop: ends when the viewer is detached or evicted.
+	for msg := range viewer.C {
+		if err := ws.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// reportHistoryError runs a history edit and tells only the requesting viewer
+// if it was rejected. Successful edits are broadcast to every viewer by the room.
+func reportHistoryError(session *chat.Session, viewer *chat.Viewer, edit func() error) {
+	if err := edit(); err != nil {
+		session.Notify(viewer, chat.Message{Sender: "System", Content: "Edit failed: " + err.Error(), Type: chat.EventSystem})
+	}
+}
+
+// clients builds the model clients of a room's participants from the
+// connection's settings and the user's provider profiles.
+type clients struct {
+	conn     llm.Config
+	profiles map[string]data.Profile
+}
+
+// get returns a client for the named profile (empty means the connection's
+// settings), with model, if set, overriding its model.
+func (cs clients) get(profile, model string, sampling llm.Sampling) (llm.Provider, error) {
+	cfg := cs.conn
+	if profile != "" {
+		p, ok := cs.profiles[profile]
 		if !ok {
-			break
+			return nil, fmt.Errorf("unknown provider profile: %s", profile)
 		}
-		if msg.Type == "full" {
-			conv.History = room.History
-			go data.SaveConversation(conv)
+		cfg = p.Config()
+	}
+	if model != "" {
+		cfg.Model = model
+	}
+	if profile == "" {
+		// Without a profile the provider is only known now, from the
+		// handshake; adjust rather than refuse settings chosen for another.
+		sampling = sampling.Clamp(cfg.Provider)
+	} else if err := sampling.Validate(cfg.Provider); err != nil {
+		return nil, fmt.Errorf("profile %s: %w", profile, err)
+	}
+	cfg.Sampling = sampling
+	return llm.New(cfg)
+}
+
+// buildRoom creates a room for conv from its persisted state. The returned
+// topic seeds StartLoop and is empty when resuming an existing history.
+// Participants with a profile or model of their own get their own client;
+// the rest share one built from llmCfg.
+func buildRoom(conv *data.Conversation, llmCfg llm.Config) (*chat.Room, string, error) {
+	if err := data.ValidateAgents(conv.Agents); err != nil {
+		return nil, "", err
+	}
+	profiles, err := data.GetProfiles(conv.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+	cs := clients{conn: llmCfg, profiles: make(map[string]data.Profile, len(profiles))}
+	for _, p := range profiles {
+		cs.profiles[p.Name] = p
+	}
+	client, err := cs.get("", "", llm.Sampling{})
+	if err != nil {
+		return nil, "", err
+	}
+
+	agents := make([]*agent.Agent, 0, len(conv.Agents))
+	for _, cfg := range conv.Agents {
+		c, err := cs.get(cfg.Profile, cfg.Model, cfg.Sampling)
+		if err != nil {
+			return nil, "", fmt.Errorf("agent %s: %w", cfg.Name, err)
 		}
-		if err := ws.WriteJSON(msg); err != nil {
-			break
+		ag := agent.NewAgent(cfg.Name, cfg.Prompt, c)
+		ag.NameStyle = conv.NameStyle
+		ag.Scratchpad = conv.Scratchpad
+		ag.Notes = conv.Notes[cfg.Name]
+		agents = append(agents, ag)
+	}
+
+	policy, err := chat.NewTurnPolicy(conv.TurnPolicy, conv.Weights())
+	if err != nil {
+		return nil, "", err
+	}
+
+	room := chat.NewRoom(agents)
+	room.Policy = policy
+	if conv.Moderator != nil {
+		prompt := conv.Moderator.Prompt
+		if prompt == "" {
+			prompt = chat.DefaultModeratorPrompt
+		}
+		c, err := cs.get(conv.Moderator.Profile, conv.Moderator.Model, conv.Moderator.Sampling)
+		if err != nil {
+			return nil, "", fmt.Errorf("moderator: %w", err)
+		}
+		mod := agent.NewAgent(conv.Moderator.Name, prompt, c)
+		mod.NameStyle = conv.NameStyle
+		room.Moderator = chat.NewModerator(mod)
+	}
+	room.NameStyle = conv.NameStyle
+	chat.EnsureIDs(conv.History)
+	room.History = conv.History
+	switch conv.Status {
+	case "paused":
+		// Stay paused until the user resumes.
+		room.Pause(false)
+	case "finished":
+		// Open it for viewing and editing only; running it again would
+		// fire the same stop rule and judge it once more.
+		room.StopReason = conv.StopReason
+		room.StopLoop()
+	}
+	room.Rules = conv.StopRules
+	room.Usage = conv.Usage
+	room.Topic = conv.Topic
+	room.JudgeClient = client
+	if conv.Judge.Profile != "" || conv.Judge.Model != "" || !conv.Judge.Sampling.IsZero() {
+		if room.JudgeClient, err = cs.get(conv.Judge.Profile, conv.Judge.Model, conv.Judge.Sampling); err != nil {
+			return nil, "", fmt.Errorf("judge: %w", err)
+		}
+	}
+	room.JudgeConfig = conv.Judge
+	room.Format = conv.Format
+	room.FormatStep = conv.FormatStep
+	room.Phase = conv.Phase
+	room.NextSpeaker = conv.NextSpeaker
+	for _, p := range conv.Judge.Panel {
+		var c llm.Provider // nil uses the judge client
+		if p.Profile != "" || p.Model != "" || !p.Sampling.IsZero() {
+			if c, err = cs.get(p.Profile, p.Model, p.Sampling); err != nil {
+				return nil, "", fmt.Errorf("judge %s: %w", p.Name, err)
+			}
 		}
+		room.PanelClients = append(room.PanelClients, c)
+	}
+	room.Verdict = conv.Verdict
+	room.Summary = conv.Summary
+	room.SummaryEvery = conv.SummaryEvery
+
+	if len(conv.History) == 0 {
+		return room, conv.Topic, nil
+	}
+	return room, "", nil
+}
+
+// syncConversation copies the room's progress onto the stored conversation.
+func syncConversation(conv *data.Conversation, snap chat.Snapshot) {
+	conv.History = snap.History
+	conv.Usage = snap.Usage
+	conv.Summary = snap.Summary
+	conv.FormatStep = snap.FormatStep
+	conv.Phase = snap.Phase
+	conv.NextSpeaker = snap.NextSpeaker
+	if snap.Verdict != nil {
+		conv.Verdict = snap.Verdict
+		conv.Winner = snap.Verdict.Winner
+	}
+	if conv.Scratchpad {
+		conv.Notes = snap.Notes
+	}
+	if snap.StopReason != "" {
+		conv.StopReason = snap.StopReason
+		conv.Status = "finished"
 	}
 }
 
@@ -247,6 +979,10 @@ func UpdateConfig(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Invalid request"})
 		return
 	}
+	if !llm.ValidProvider(cfg.Provider) {
+		c.JSON(400, gin.H{"error": "Unknown provider: " + cfg.Provider})
+		return
+	}
 
 	if err := data.SaveChatConfig(userID, &cfg); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
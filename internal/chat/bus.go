@@ -0,0 +1,256 @@
+package chat
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is the kind of a room event (Message.Type).
+type EventType string
+
+// Room event types. History entries are always EventMessage.
+const (
+	EventTurnStart    EventType = "start"
+	EventChunk        EventType = "chunk"
+	EventTurnEnd      EventType = "end"
+	EventMessage      EventType = "full" // A complete message (seed, steer, judge, history entry)
+	EventIntervention EventType = "intervention"
+	EventSystem       EventType = "system"
+	EventStatus       EventType = "status"
+	EventCommand      EventType = "cmd"
+	EventEdit         EventType = "edit"
+	EventDelete       EventType = "delete"
+	EventSummary      EventType = "summary"
+	EventPhase        EventType = "phase"
+	EventBallot       EventType = "ballot"
+	EventVerdict      EventType = "verdict"
+	EventError        EventType = "error"
+	EventSnapshot     EventType = "snapshot" // Private: a Snapshot for a viewer resuming past the backlog
+)
+
+// Event is a Message as published on a room's Bus. Seq increases by one
+// per published event; private notices sent to a single subscriber have Seq 0.
+// A bus starts counting from its creation time in microseconds, so a number
+// left over from an earlier bus for the same room is never mistaken for one
+// of its own.
+type Event struct {
+	Message
+	Seq  uint64    `json:"seq"`
+	Time time.Time `json:"time"`
+}
+
+// Overflow decides what happens when a subscriber's buffer is full.
+type Overflow int
+
+const (
+	// OverflowDisconnect closes the subscription, so the reader notices the gap.
+	OverflowDisconnect Overflow = iota
+	// OverflowDropOldest discards the oldest buffered event to make room.
+	OverflowDropOldest
+	// OverflowDropNewest discards the event being published.
+	OverflowDropNewest
+)
+
+// replaySize is how many recent events a bus keeps for resuming subscribers.
+// Chunks count too, so this covers a few long turns.
+const replaySize = 4096
+
+// Bus fans room events out to any number of subscribers. Publishing never
+// blocks: each subscriber has its own bounded buffer and overflow policy,
+// so a slow or missing reader can't stall the conversation loop.
+type Bus struct {
+	mu     sync.Mutex
+	seq    uint64
+	subs   map[*Subscription]struct{}
+	closed bool
+
+	// backlog is a ring of the last replaySize events; next is the
+	// slot the next event goes in once it is full.
+	backlog []Event
+	next    int
+}
+
+// NewBus creates a bus with no subscribers.
+func NewBus() *Bus {
+	return &Bus{
+		seq:  uint64(time.Now().UnixMicro()),
+		subs: make(map[*Subscription]struct{}),
+	}
+}
+
+// Subscription receives events on C until it is closed, by Close, by the
+// bus closing, or by OverflowDisconnect.
+type Subscription struct {
+	C <-chan Event
+
+	c        chan Event
+	bus      *Bus
+	overflow Overflow
+	dropped  atomic.Uint64
+	closed   bool // Guarded by bus.mu
+}
+
+// Subscribe adds a subscriber with the given buffer size and overflow policy.
+// Subscribing to a closed bus returns an already closed subscription.
+func (b *Bus) Subscribe(buffer int, overflow Overflow) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.subscribeLocked(buffer, overflow, nil)
+}
+
+// SubscribeFrom subscribes a reader that last saw event after. The new
+// subscription first receives prelude as private notices, then every event
+// after that one, then live events, with nothing missed or repeated.
+// It returns false, without subscribing, if the bus no longer holds the
+// events after after (or never published it).
+func (b *Bus) SubscribeFrom(after uint64, buffer int, overflow Overflow, prelude ...Message) (*Subscription, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	replay, ok := b.sinceLocked(after)
+	if !ok {
+		return nil, false
+	}
+	now := time.Now()
+	first := make([]Event, 0, len(prelude)+len(replay))
+	for _, msg := range prelude {
+		first = append(first, Event{Message: msg, Time: now})
+	}
+	return b.subscribeLocked(buffer, overflow, append(first, replay...)), true
+}
+
+// subscribeLocked adds a subscriber whose channel starts out holding first,
+// on top of its buffer.
+func (b *Bus) subscribeLocked(buffer int, overflow Overflow, first []Event) *Subscription {
+	c := make(chan Event, max(buffer, 1)+len(first))
+	s := &Subscription{C: c, c: c, bus: b, overflow: overflow}
+	for _, ev := range first {
+		c <- ev
+	}
+	if b.closed {
+		s.closed = true
+		close(c)
+		return s
+	}
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// sinceLocked returns the retained events after seq after, oldest first.
+func (b *Bus) sinceLocked(after uint64) ([]Event, bool) {
+	if after > b.seq {
+		return nil, false
+	}
+	missed := b.seq - after
+	if missed > uint64(len(b.backlog)) {
+		return nil, false
+	}
+	events := make([]Event, 0, missed)
+	for i := len(b.backlog) - int(missed); i < len(b.backlog); i++ {
+		events = append(events, b.backlog[(b.next+i)%len(b.backlog)])
+	}
+	return events, true
+}
+
+// Publish stamps msg with the next sequence number and the current time,
+// and delivers it to every subscriber. It returns the published event.
+func (b *Bus) Publish(msg Message) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return Event{Message: msg, Time: time.Now()}
+	}
+	b.seq++
+	ev := Event{Message: msg, Seq: b.seq, Time: time.Now()}
+	if len(b.backlog) < replaySize {
+		b.backlog = append(b.backlog, ev)
+	} else {
+		b.backlog[b.next] = ev
+		b.next = (b.next + 1) % replaySize
+	}
+	for s := range b.subs {
+		b.deliverLocked(s, ev)
+	}
+	return ev
+}
+
+// Seq returns the sequence number of the last published event.
+func (b *Bus) Seq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.seq
+}
+
+// Close closes every subscription. Later publishes go nowhere.
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	for s := range b.subs {
+		b.closeLocked(s)
+	}
+}
+
+func (b *Bus) deliverLocked(s *Subscription, ev Event) {
+	select {
+	case s.c <- ev:
+		return
+	default:
+	}
+
+	switch s.overflow {
+	case OverflowDropOldest:
+		// Only publishers (holding b.mu) send, so after taking one
+		// event out there is room for this one.
+		select {
+		case <-s.c:
+			s.dropped.Add(1)
+		default:
+		}
+		select {
+		case s.c <- ev:
+		default:
+			s.dropped.Add(1)
+		}
+	case OverflowDropNewest:
+		s.dropped.Add(1)
+	default:
+		s.dropped.Add(1)
+		b.closeLocked(s)
+	}
+}
+
+func (b *Bus) closeLocked(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(b.subs, s)
+	close(s.c)
+}
+
+// Send delivers a private notice to this subscriber only (Seq 0), under
+// its overflow policy. It is dropped if the subscription is closed.
+func (s *Subscription) Send(msg Message) {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.bus.deliverLocked(s, Event{Message: msg, Time: time.Now()})
+}
+
+// Close unsubscribes. Closing twice is harmless.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.closeLocked(s)
+}
+
+// Dropped returns how many events this subscriber has missed to overflow.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
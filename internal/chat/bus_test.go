@@ -0,0 +1,83 @@
+package chat
+
+import "testing"
+
+func TestBusSequenceAndOverflow(t *testing.T) {
+	bus := NewBus()
+	base := bus.Seq()
+	fast := bus.Subscribe(8, OverflowDisconnect)
+	oldest := bus.Subscribe(2, OverflowDropOldest)
+	newest := bus.Subscribe(2, OverflowDropNewest)
+	slow := bus.Subscribe(2, OverflowDisconnect)
+
+	for _, c := range []string{"a", "b", "c"} {
+		bus.Publish(Message{Content: c, Type: EventChunk})
+	}
+
+	for want := base + 1; want <= base+3; want++ {
+		if ev := <-fast.C; ev.Seq != want || ev.Time.IsZero() {
+			t.Fatalf("event = %+v, want seq %d with a timestamp", ev, want)
+		}
+	}
+	if a, b := <-oldest.C, <-oldest.C; a.Content != "b" || b.Content != "c" || oldest.Dropped() != 1 {
+		t.Fatalf("drop-oldest kept %q %q (dropped %d), want b c", a.Content, b.Content, oldest.Dropped())
+	}
+	if a, b := <-newest.C, <-newest.C; a.Content != "a" || b.Content != "b" || newest.Dropped() != 1 {
+		t.Fatalf("drop-newest kept %q %q (dropped %d), want a b", a.Content, b.Content, newest.Dropped())
+	}
+
+	// The slow subscriber got two events, then was disconnected.
+	<-slow.C
+	<-slow.C
+	if _, ok := <-slow.C; ok {
+		t.Fatal("expected the slow subscription to be closed")
+	}
+
+	// Private notices don't consume sequence numbers.
+	fast.Send(Message{Content: "just you"})
+	bus.Publish(Message{Content: "d"})
+	if ev := <-fast.C; ev.Seq != 0 || ev.Content != "just you" {
+		t.Fatalf("notice = %+v, want seq 0", ev)
+	}
+	if ev := <-fast.C; ev.Seq != base+4 {
+		t.Fatalf("seq after notice = %d, want %d", ev.Seq, base+4)
+	}
+
+	bus.Close()
+	if _, ok := <-fast.C; ok {
+		t.Fatal("expected Close to end subscriptions")
+	}
+	fast.Close() // Harmless after the bus closed
+}
+
+func TestBusSubscribeFrom(t *testing.T) {
+	bus := NewBus()
+	for i := 0; i < replaySize+10; i++ {
+		bus.Publish(Message{Type: EventChunk})
+	}
+	last := bus.Seq()
+
+	sub, ok := bus.SubscribeFrom(last-2, 1, OverflowDisconnect, Message{Content: "hello"})
+	if !ok {
+		t.Fatal("expected a resume from a retained event")
+	}
+	bus.Publish(Message{Content: "live"})
+	if ev := <-sub.C; ev.Seq != 0 || ev.Content != "hello" {
+		t.Fatalf("first event = %+v, want the prelude", ev)
+	}
+	for want := last - 1; want <= last+1; want++ {
+		if ev := <-sub.C; ev.Seq != want {
+			t.Fatalf("seq = %d, want %d", ev.Seq, want)
+		}
+	}
+
+	if _, ok := bus.SubscribeFrom(last-replaySize-1, 1, OverflowDisconnect); ok {
+		t.Fatal("resumed from an event that fell out of the backlog")
+	}
+	if _, ok := bus.SubscribeFrom(last+5, 1, OverflowDisconnect); ok {
+		t.Fatal("resumed from an event that was never published")
+	}
+	if sub, ok := bus.SubscribeFrom(bus.Seq(), 1, OverflowDisconnect); !ok || len(sub.C) != 0 {
+		t.Fatal("resuming from the latest event should replay nothing")
+	}
+}
@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"fmt"
+	"qigent/internal/llm"
+)
+
+// Phase is one stage of a structured debate.
+type Phase struct {
+	Name string `json:"name"`
+	// Speakers is the speaking order by agent name; empty means every agent in order.
+	Speakers []string `json:"speakers,omitempty"`
+	// Rounds is how many times the order is repeated (0 means once).
+	Rounds int `json:"rounds,omitempty"`
+	// Instruction is added to the prompt of every turn in this phase.
+	Instruction string `json:"instruction,omitempty"`
+	// MaxTokens cuts a turn off once its reply reaches this length (0 means no limit).
+	MaxTokens int `json:"maxTokens,omitempty"`
+}
+
+// Format is a sequence of phases. A room with a format follows its
+// schedule instead of the turn policy or moderator, and ends after the last phase.
+type Format struct {
+	Name   string  `json:"name,omitempty"`
+	Phases []Phase `json:"phases,omitempty"`
+}
+
+// Formats are the built-in formats, by name.
+var Formats = map[string]Format{
+	"standard": {Name: "standard", Phases: []Phase{
+		{Name: "Opening statements", Instruction: "Give your opening statement: state your position and your main arguments.", MaxTokens: 400},
+		{Name: "Rebuttals", Instruction: "Rebut the strongest points made against your position so far.", MaxTokens: 300},
+		{Name: "Cross-examination", Rounds: 2, Instruction: "Answer any question put to you, then ask your opponent one pointed question.", MaxTokens: 200},
+		{Name: "Closing statements", Instruction: "Give your closing statement: summarize why your position prevailed. No new arguments.", MaxTokens: 300},
+	}},
+	"lightning": {Name: "lightning", Phases: []Phase{
+		{Name: "Pitch", Instruction: "Make your case in a few sentences.", MaxTokens: 120},
+		{Name: "Response", Instruction: "Respond to the other pitches in a few sentences.", MaxTokens: 120},
+	}},
+}
+
+// Resolve fills in the phases of a built-in format referenced only by name.
+func (f *Format) Resolve() error {
+	if len(f.Phases) > 0 {
+		return nil
+	}
+	builtin, ok := Formats[f.Name]
+	if !ok {
+		return fmt.Errorf("unknown debate format: %s", f.Name)
+	}
+	*f = builtin
+	return nil
+}
+
+// Validate checks the phases against the participating agents.
+func (f Format) Validate(agents []string) error {
+	if len(f.Phases) == 0 {
+		return fmt.Errorf("debate format needs at least one phase")
+	}
+	known := make(map[string]bool)
+	for _, a := range agents {
+		known[a] = true
+	}
+	for _, p := range f.Phases {
+		if p.Name == "" {
+			return fmt.Errorf("every phase needs a name")
+		}
+		if p.Rounds < 0 || p.MaxTokens < 0 {
+			return fmt.Errorf("phase %s: rounds and maxTokens must not be negative", p.Name)
+		}
+		for _, s := range p.Speakers {
+			if !known[s] {
+				return fmt.Errorf("phase %s: unknown speaker %s", p.Name, s)
+			}
+		}
+	}
+	return nil
+}
+
+// At locates a step of the schedule: the phase it belongs to, and which
+// agent speaks. ok is false once the schedule is finished.
+func (f Format) At(step int, agents []string) (phase int, speaker string, ok bool) {
+	for i, p := range f.Phases {
+		order := p.Speakers
+		if len(order) == 0 {
+			order = agents
+		}
+		n := len(order) * max(p.Rounds, 1)
+		if step < n {
+			return i, order[step%len(order)], true
+		}
+		step -= n
+	}
+	return -1, "", false
+}
+
+// instruction is the speaker-less turn appended to the prompt during p.
+func (p Phase) instruction() llm.Turn {
+	text := "Current phase: " + p.Name + "."
+	if p.Instruction != "" {
+		text += " " + p.Instruction
+	}
+	if p.MaxTokens > 0 {
+		text += fmt.Sprintf(" Keep your reply under about %d tokens; longer replies are cut off.", p.MaxTokens)
+	}
+	return llm.Turn{Content: text, Pinned: true}
+}
@@ -0,0 +1,44 @@
+package chat
+
+import "testing"
+
+func TestFormatSchedule(t *testing.T) {
+	f := Format{Phases: []Phase{
+		{Name: "Opening"},
+		{Name: "Cross", Speakers: []string{"B", "A"}, Rounds: 2},
+		{Name: "Closing", Speakers: []string{"A"}},
+	}}
+	agents := []string{"A", "B"}
+
+	want := []struct {
+		phase   int
+		speaker string
+	}{{0, "A"}, {0, "B"}, {1, "B"}, {1, "A"}, {1, "B"}, {1, "A"}, {2, "A"}}
+	for step, w := range want {
+		phase, speaker, ok := f.At(step, agents)
+		if !ok || phase != w.phase || speaker != w.speaker {
+			t.Fatalf("At(%d) = %d %q %v, want %d %q", step, phase, speaker, ok, w.phase, w.speaker)
+		}
+	}
+	if _, _, ok := f.At(len(want), agents); ok {
+		t.Fatal("expected the schedule to be finished")
+	}
+}
+
+func TestFormatResolveAndValidate(t *testing.T) {
+	f := Format{Name: "standard"}
+	if err := f.Resolve(); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Phases) == 0 {
+		t.Fatal("built-in format has no phases")
+	}
+	if err := (&Format{Name: "nope"}).Resolve(); err == nil {
+		t.Fatal("expected error for unknown format")
+	}
+
+	bad := Format{Phases: []Phase{{Name: "Opening", Speakers: []string{"C"}}}}
+	if err := bad.Validate([]string{"A", "B"}); err == nil {
+		t.Fatal("expected error for unknown speaker")
+	}
+}
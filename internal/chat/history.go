@@ -0,0 +1,151 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"qigent/internal/agent"
+	"qigent/internal/llm"
+)
+
+// ErrMessageNotFound is returned when an edit targets an unknown message ID.
+var ErrMessageNotFound = errors.New("message not found")
+
+// transcript converts stored history into model-facing turns. History
+// content is raw text; speaker names are applied later per llm.NameStyle.
+func transcript(history []Message) []llm.Turn {
+	turns := make([]llm.Turn, 0, len(history))
+	for _, m := range history {
+		turns = append(turns, llm.Turn{Speaker: m.Sender, Content: m.Content})
+	}
+	return turns
+}
+
+// seedText is the moderator's opening message for a topic.
+func seedText(topic string) string {
+	return "Please discuss the topic: " + topic
+}
+
+// topicTurn is the topic as a pinned prompt turn, so it survives trimming
+// however the history starts: with the seed, an intervention in a fork, or
+// nothing at all once the seed was deleted.
+func topicTurn(topic string) llm.Turn {
+	return llm.Turn{Content: seedText(topic), Pinned: true}
+}
+
+// seedLen is 1 if history opens with the topic seed, which topicTurn
+// replaces in prompts, and 0 otherwise.
+func seedLen(history []Message, topic string) int {
+	if topic != "" && len(history) > 0 && history[0].Content == seedText(topic) {
+		return 1
+	}
+	return 0
+}
+
+// EnsureIDs assigns an ID to every history message that lacks one.
+// It reports whether anything changed.
+func EnsureIDs(history []Message) bool {
+	changed := false
+	for i := range history {
+		if history[i].ID == "" {
+			history[i].ID = NewID()
+			changed = true
+		}
+	}
+	return changed
+}
+
+func indexOfID(history []Message, id string) int {
+	for i, m := range history {
+		if m.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// EditMessage replaces the text of the message with the given ID.
+func EditMessage(history []Message, id, text string) ([]Message, error) {
+	i := indexOfID(history, id)
+	if i < 0 {
+		return history, ErrMessageNotFound
+	}
+	history[i].Content = text
+	return history, nil
+}
+
+// DeleteMessage removes the message with the given ID.
+func DeleteMessage(history []Message, id string) ([]Message, error) {
+	i := indexOfID(history, id)
+	if i < 0 {
+		return history, ErrMessageNotFound
+	}
+	return append(history[:i], history[i+1:]...), nil
+}
+
+// PopLastAgentTurn removes the final history message so it can be
+// regenerated. It must be a turn by one of agentNames; the removed message is returned.
+func PopLastAgentTurn(history []Message, agentNames []string) ([]Message, Message, error) {
+	if len(history) == 0 {
+		return history, Message{}, fmt.Errorf("history is empty")
+	}
+	last := history[len(history)-1]
+	for _, name := range agentNames {
+		if last.Sender == name {
+			return history[:len(history)-1], last, nil
+		}
+	}
+	return history, Message{}, fmt.Errorf("last message is not an agent turn (from %s)", last.Sender)
+}
+
+// Progress is what a history edit has to keep in step with the history:
+// where the summary ends, the position in the format, who speaks next and
+// the agents' notes, by agent name. A running room and a stored
+// conversation are edited through it alike.
+type Progress struct {
+	History     []Message
+	Summary     Summary
+	FormatStep  int
+	NextSpeaker string
+	Notes       map[string][]agent.Note
+}
+
+// Delete removes the message with the given ID, and the notes written
+// during it. If the summary ended there it now ends one message earlier,
+// so it stays anchored.
+func (p *Progress) Delete(id string) error {
+	if id == p.Summary.Through {
+		if i := indexOfID(p.History, id); i > 0 {
+			p.Summary.Through = p.History[i-1].ID
+		}
+	}
+	var err error
+	if p.History, err = DeleteMessage(p.History, id); err == nil {
+		p.forget(id)
+	}
+	return err
+}
+
+// forget drops the notes written during the turn with the given ID.
+func (p *Progress) forget(id string) {
+	for name, notes := range p.Notes {
+		p.Notes[name] = agent.ForgetTurn(notes, id)
+	}
+}
+
+// Regenerate removes the last agent turn so that the same agent takes it
+// again: its speaker is made next, its notes are dropped and the format, if
+// any, goes back a step (FormatStep only advances under a format). The
+// removed turn is returned.
+func (p *Progress) Regenerate(agentNames []string) (Message, error) {
+	history, removed, err := PopLastAgentTurn(p.History, agentNames)
+	if err != nil {
+		return removed, err
+	}
+	p.History = history
+	if p.FormatStep > 0 {
+		p.FormatStep--
+	}
+	p.NextSpeaker = removed.Sender
+	p.forget(removed.ID)
+	return removed, nil
+}
@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"qigent/internal/agent"
+	"testing"
+)
+
+func TestHistoryEdits(t *testing.T) {
+	history := []Message{
+		{Sender: "Moderator", Content: "topic"},
+		{Sender: "A", Content: "one"},
+		{Sender: "User", Content: "hey"},
+		{Sender: "B", Content: "two"},
+	}
+	if !EnsureIDs(history) || EnsureIDs(history) {
+		t.Fatal("EnsureIDs should assign IDs exactly once")
+	}
+
+	history, err := EditMessage(history, history[2].ID, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history[2].Content != "hello" {
+		t.Fatalf("edited content = %q", history[2].Content)
+	}
+
+	history, removed, err := PopLastAgentTurn(history, []string{"A", "B"})
+	if err != nil || removed.Sender != "B" || len(history) != 3 {
+		t.Fatalf("PopLastAgentTurn: removed=%+v len=%d err=%v", removed, len(history), err)
+	}
+	if _, _, err := PopLastAgentTurn(history, []string{"A", "B"}); err == nil {
+		t.Fatal("expected error when last message is not an agent turn")
+	}
+
+	history, err = DeleteMessage(history, history[1].ID)
+	if err != nil || len(history) != 2 || history[1].Sender != "User" {
+		t.Fatalf("DeleteMessage: len=%d err=%v", len(history), err)
+	}
+	if _, err := DeleteMessage(history, "missing"); err != ErrMessageNotFound {
+		t.Fatalf("DeleteMessage(missing) err = %v", err)
+	}
+}
+
+func TestProgressRegenerate(t *testing.T) {
+	p := Progress{
+		History:    []Message{{ID: "1", Sender: "Moderator"}, {ID: "2", Sender: "A"}, {ID: "3", Sender: "B"}},
+		FormatStep: 2,
+		Notes:      map[string][]agent.Note{"B": {{Text: "keep", TurnID: "0"}, {Text: "drop", TurnID: "3"}}},
+	}
+	removed, err := p.Regenerate([]string{"A", "B"})
+	if err != nil || removed.ID != "3" {
+		t.Fatalf("removed = %+v, err = %v", removed, err)
+	}
+	if len(p.History) != 2 || p.FormatStep != 1 || p.NextSpeaker != "B" {
+		t.Errorf("progress = %+v, want B's turn rewound and B next", p)
+	}
+	if notes := p.Notes["B"]; len(notes) != 1 || notes[0].Text != "keep" {
+		t.Errorf("notes = %+v, want the regenerated turn's dropped", notes)
+	}
+}
+
+func TestProgressDeleteKeepsSummaryAnchored(t *testing.T) {
+	p := Progress{
+		History: []Message{{ID: "1"}, {ID: "2"}, {ID: "3"}},
+		Summary: Summary{Text: "so far", Through: "2"},
+	}
+	if err := p.Delete("2"); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.History) != 2 || p.Summary.Through != "1" {
+		t.Errorf("progress = %+v, want the summary moved back to 1", p)
+	}
+	if err := p.Delete("missing"); err != ErrMessageNotFound {
+		t.Errorf("Delete(missing) err = %v", err)
+	}
+}
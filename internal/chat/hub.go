@@ -0,0 +1,371 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// What a session does when its last viewer detaches.
+const (
+	LeaveKeepRunning = "keep"  // Keep the room talking with nobody watching
+	LeavePause       = "pause" // Stop the room and mark the conversation paused
+	LeaveStop        = "stop"  // Stop the room (default)
+)
+
+// ValidLeavePolicy reports whether p is a known leave policy ("" means LeaveStop).
+func ValidLeavePolicy(p string) bool {
+	switch p {
+	case "", LeaveKeepRunning, LeavePause, LeaveStop:
+		return true
+	}
+	return false
+}
+
+// ErrNotRunning is returned when a spectator attaches to a conversation with no running room.
+var ErrNotRunning = errors.New("conversation is not running")
+
+// viewerBuffer is how many events a viewer may lag behind before it is evicted.
+const viewerBuffer = 256
+
+// snapshotTimeout bounds how long a resuming viewer waits for the room's
+// loop to take a snapshot.
+const snapshotTimeout = 10 * time.Second
+
+// pumpBuffer is the session's own subscription, used for persistence. It
+// drops the oldest events rather than disconnect; OnEvent syncs whole state.
+const pumpBuffer = 1024
+
+// SessionConfig tells the hub how to run a conversation's room.
+type SessionConfig struct {
+	// Build creates the room from persisted state, plus the topic to seed StartLoop with.
+	Build func() (*Room, string, error)
+	// OnEvent sees the room's events in order, on one goroutine (e.g. to persist).
+	// Viewers receive events independently and may see them first.
+	OnEvent func(room *Room, ev Event)
+	// OnClose runs after the room's owner and the last OnEvent have returned.
+	// status is "paused" when the room was paused by the leave policy, empty otherwise.
+	OnClose func(room *Room, status string)
+	// OnLastLeave is one of the Leave* policies.
+	OnLastLeave string
+}
+
+// Viewer is one attached client. Events arrive on C, which is closed
+// when the viewer detaches, is evicted for lagging, or the session ends.
+type Viewer struct {
+	C     <-chan Event
+	Owner bool // Owners may send commands; spectators only watch
+
+	sub   *Subscription
+	stale bool // Resumed from an event the room no longer has; needs a snapshot
+}
+
+// Session is a running room shared by any number of viewers.
+type Session struct {
+	ID string
+
+	hub    *Hub
+	cfg    SessionConfig
+	room   *Room
+	ctx    context.Context
+	cancel context.CancelFunc
+	pumped chan struct{} // Closed when pump returns
+	ended  chan struct{} // Closed once close has run OnClose
+
+	mu       sync.Mutex
+	viewers  map[*Viewer]struct{}
+	finished bool // The room sent its final "stop" command
+	closed   bool
+}
+
+// Hub is the process-wide registry of running rooms, keyed by conversation ID.
+type Hub struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+// DefaultHub is the hub used by the HTTP handlers.
+var DefaultHub = NewHub()
+
+// NewHub creates an empty hub.
+func NewHub() *Hub {
+	return &Hub{sessions: make(map[string]*Session)}
+}
+
+// Get returns the running session for a conversation, or nil.
+func (h *Hub) Get(id string) *Session {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sessions[id]
+}
+
+// Attach adds a viewer to the conversation's room, starting the room first
+// if it isn't running. A nil cfg attaches a spectator and never starts a room.
+//
+// A reconnecting client passes the Seq of the last event it saw as since
+// (0 for a fresh connection) and is first sent everything after it. If the
+// room no longer has those events, the viewer gets an EventSnapshot instead.
+func (h *Hub) Attach(id string, cfg *SessionConfig, since uint64) (*Session, *Viewer, error) {
+	s, v, err := h.attach(id, cfg, since)
+	if err == nil && v.stale {
+		// Outside h.mu: the snapshot waits for the room's loop.
+		s.resync(v)
+	}
+	return s, v, err
+}
+
+func (h *Hub) attach(id string, cfg *SessionConfig, since uint64) (*Session, *Viewer, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.sessions[id]
+	if s != nil {
+		if v := s.addViewer(cfg != nil, since); v != nil {
+			return s, v, nil
+		}
+		// Lost a race with the session shutting down; start a fresh one.
+	}
+
+	if cfg == nil {
+		return nil, nil, ErrNotRunning
+	}
+
+	room, topic, err := cfg.Build()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s = &Session{
+		ID:      id,
+		hub:     h,
+		cfg:     *cfg,
+		room:    room,
+		ctx:     ctx,
+		cancel:  cancel,
+		pumped:  make(chan struct{}),
+		ended:   make(chan struct{}),
+		viewers: make(map[*Viewer]struct{}),
+	}
+	h.sessions[id] = s
+
+	v := s.addViewer(true, since)
+	go s.pump(room.Events.Subscribe(pumpBuffer, OverflowDropOldest))
+	room.StartLoop(ctx, topic)
+	log.Printf("Hub: started room for conversation %s", id)
+	return s, v, nil
+}
+
+// Stop closes the conversation's session, if one is running, whatever its
+// leave policy: the room stops, OnClose runs with status ("" keeps the
+// room's own) and every viewer's event stream ends. It returns once OnClose
+// has run, so the caller may then delete what OnClose saves. It reports
+// whether a session was running.
+func (h *Hub) Stop(id, status string) bool {
+	s := h.Get(id)
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	closing := !s.closed
+	s.closed = true
+	s.mu.Unlock()
+
+	if closing {
+		s.close(status)
+	} else {
+		<-s.ended // Someone else is closing it
+	}
+	return true
+}
+
+func (h *Hub) remove(s *Session) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.sessions[s.ID] == s {
+		delete(h.sessions, s.ID)
+	}
+}
+
+// Room returns the session's room.
+func (s *Session) Room() *Room {
+	return s.room
+}
+
+// Context is cancelled when the session shuts down. Use it for work started
+// on behalf of the room (e.g. judging) rather than a single connection's context.
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// Viewers returns the number of attached viewers.
+func (s *Session) Viewers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.viewers)
+}
+
+// Notify sends msg to a single viewer, e.g. an error meant only for it.
+// Dropped if the viewer is gone.
+func (s *Session) Notify(v *Viewer, msg Message) {
+	v.sub.Send(msg)
+}
+
+// addViewer returns nil if the session is already shutting down. A viewer
+// resuming from an event that is no longer retained is subscribed to live
+// events and marked stale.
+func (s *Session) addViewer(owner bool, since uint64) *Viewer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	// A lagging viewer is disconnected rather than fed a gap; its
+	// connection ends and the client can reconnect.
+	var sub *Subscription
+	resumed := false
+	if since > 0 {
+		sub, resumed = s.room.Events.SubscribeFrom(since, viewerBuffer, OverflowDisconnect)
+	}
+	if !resumed {
+		sub = s.room.Events.Subscribe(viewerBuffer, OverflowDisconnect)
+	}
+	v := &Viewer{C: sub.C, Owner: owner, sub: sub, stale: since > 0 && !resumed}
+	s.viewers[v] = struct{}{}
+	return v
+}
+
+// resync gives a stale viewer a snapshot of the room followed by every
+// event after it, in place of its live subscription. The viewer hasn't been
+// handed out yet, so nothing else reads its channel. If no snapshot can be
+// taken it keeps the live events and is told it may be missing some.
+func (s *Session) resync(v *Viewer) {
+	ctx, cancel := context.WithTimeout(s.ctx, snapshotTimeout)
+	defer cancel()
+
+	// Retry if the backlog moves past the snapshot before we subscribe.
+	for range 3 {
+		snap, err := s.room.Snapshot(ctx)
+		if err != nil {
+			log.Printf("Hub: snapshot of room %s failed: %v", s.ID, err)
+			break
+		}
+		content, err := json.Marshal(snap)
+		if err != nil {
+			log.Printf("Hub: snapshot of room %s failed: %v", s.ID, err)
+			break
+		}
+		notice := Message{Sender: "System", Content: string(content), Type: EventSnapshot}
+		sub, ok := s.room.Events.SubscribeFrom(snap.Seq, viewerBuffer, OverflowDisconnect, notice)
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		old := v.sub
+		v.C, v.sub, v.stale = sub.C, sub, false
+		s.mu.Unlock()
+		old.Close()
+		return
+	}
+	v.sub.Send(Message{Sender: "System", Content: "Could not catch up on missed events; reload the conversation.", Type: EventSystem})
+}
+
+// Detach removes a viewer. When the last one leaves, the leave policy decides
+// whether the room keeps running. Detaching twice is harmless.
+func (s *Session) Detach(v *Viewer) {
+	s.mu.Lock()
+	if _, ok := s.viewers[v]; !ok {
+		s.mu.Unlock()
+		return
+	}
+	delete(s.viewers, v)
+	v.sub.Close()
+	status, closing := s.lastLeaveLocked()
+	s.mu.Unlock()
+
+	if closing {
+		s.close(status)
+	}
+}
+
+// lastLeaveLocked applies the leave policy if no viewers remain.
+// It returns whether the session must close, and with which status.
+func (s *Session) lastLeaveLocked() (string, bool) {
+	if len(s.viewers) > 0 || s.closed {
+		return "", false
+	}
+	if !s.finished && s.cfg.OnLastLeave == LeaveKeepRunning {
+		return "", false
+	}
+	s.closed = true
+	if !s.finished && s.cfg.OnLastLeave == LeavePause {
+		return "paused", true
+	}
+	return "", true
+}
+
+// close stops the room, waits for its loop to flush, and runs OnClose.
+func (s *Session) close(status string) {
+	s.hub.remove(s)
+	s.room.StopLoop()
+	s.cancel()
+
+	select {
+	case <-s.room.Done():
+	case <-time.After(5 * time.Second):
+		log.Printf("Hub: room %s did not exit in time", s.ID)
+	}
+	<-s.pumped
+
+	if s.cfg.OnClose != nil {
+		s.cfg.OnClose(s.room, status)
+	}
+	close(s.ended)
+	// Ends every viewer's event stream.
+	s.room.Events.Close()
+	log.Printf("Hub: closed room for conversation %s", s.ID)
+}
+
+// pump feeds OnEvent from the session's own subscription and notices the
+// room's final "stop" command. An evicted viewer's channel closes; its
+// connection then detaches it, which applies the leave policy.
+func (s *Session) pump(sub *Subscription) {
+	defer close(s.pumped)
+	defer sub.Close()
+	for {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			if ev.Type == EventChunk {
+				continue
+			}
+			if s.cfg.OnEvent != nil {
+				s.cfg.OnEvent(s.room, ev)
+			}
+			if ev.Type == EventCommand && ev.Content == "stop" {
+				s.finish()
+			}
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// finish records that the room ended on its own. If nobody is watching,
+// the session closes now.
+func (s *Session) finish() {
+	s.mu.Lock()
+	s.finished = true
+	status, closing := s.lastLeaveLocked()
+	s.mu.Unlock()
+
+	if closing {
+		// Not on the pump goroutine: close waits for the room to exit.
+		go s.close(status)
+	}
+}
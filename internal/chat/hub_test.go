@@ -0,0 +1,156 @@
+package chat
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestHubSharesRoomBetweenViewers(t *testing.T) {
+	hub := NewHub()
+	builds := 0
+	closed := make(chan string, 1)
+	cfg := &SessionConfig{
+		Build: func() (*Room, string, error) {
+			builds++
+			return NewRoom(testAgents("A", "B")), "", nil
+		},
+		OnClose:     func(_ *Room, status string) { closed <- status },
+		OnLastLeave: LeavePause,
+	}
+
+	s1, v1, err := hub.Attach("c1", cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, v2, err := hub.Attach("c1", cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3, v3, err := hub.Attach("c1", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s1 != s2 || s1 != s3 || builds != 1 {
+		t.Fatalf("expected one shared session, got builds=%d", builds)
+	}
+	if !v1.Owner || v3.Owner {
+		t.Fatal("spectator should not be an owner")
+	}
+
+	// Every viewer sees the first turn.
+	for _, v := range []*Viewer{v1, v2, v3} {
+		select {
+		case msg := <-v.C:
+			if msg.Type != "start" || msg.Sender != "A" {
+				t.Fatalf("unexpected first message: %+v", msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timeout waiting for fan-out")
+		}
+	}
+
+	s1.Detach(v1)
+	s1.Detach(v2)
+	if hub.Get("c1") == nil {
+		t.Fatal("session closed while a viewer is still attached")
+	}
+
+	s1.Detach(v3)
+	select {
+	case status := <-closed:
+		if status != "paused" {
+			t.Fatalf("OnClose status = %q, want paused", status)
+		}
+	case <-time.After(6 * time.Second):
+		t.Fatal("timeout waiting for session to close")
+	}
+	if hub.Get("c1") != nil {
+		t.Fatal("closed session still registered")
+	}
+
+	if _, _, err := hub.Attach("c1", nil, 0); err != ErrNotRunning {
+		t.Fatalf("spectator attach to stopped room: err = %v, want ErrNotRunning", err)
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	hub := NewHub()
+	cfg := &SessionConfig{
+		Build: func() (*Room, string, error) {
+			return NewRoom(testAgents("A", "B")), "", nil
+		},
+	}
+
+	s, v1, err := hub.Attach("c1", cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Detach(v1)
+	var first Event
+	select {
+	case first = <-v1.C:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for the first event")
+	}
+
+	// A viewer that saw the first event picks up right after it.
+	_, v2, err := hub.Attach("c1", nil, first.Seq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Detach(v2)
+	if ev := <-v2.C; ev.Seq != first.Seq+1 {
+		t.Fatalf("resumed at seq %d, want %d", ev.Seq, first.Seq+1)
+	}
+
+	// One that is too far behind gets a snapshot first.
+	_, v3, err := hub.Attach("c1", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Detach(v3)
+	ev := <-v3.C
+	var snap Snapshot
+	if ev.Type != EventSnapshot || json.Unmarshal([]byte(ev.Content), &snap) != nil {
+		t.Fatalf("expected a snapshot, got %+v", ev)
+	}
+	if next := <-v3.C; next.Seq != snap.Seq+1 {
+		t.Fatalf("event after snapshot has seq %d, want %d", next.Seq, snap.Seq+1)
+	}
+}
+
+// Stop closes a room nobody would otherwise stop, and OnClose has run by
+// the time it returns.
+func TestHubStop(t *testing.T) {
+	hub := NewHub()
+	closed := make(chan string, 1)
+	cfg := &SessionConfig{
+		Build:       func() (*Room, string, error) { return NewRoom(testAgents("A")), "", nil },
+		OnClose:     func(_ *Room, status string) { closed <- status },
+		OnLastLeave: LeaveKeepRunning,
+	}
+	s, v, err := hub.Attach("c1", cfg, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Detach(v)
+	if hub.Get("c1") == nil {
+		t.Fatal("keep-running room closed when its viewer left")
+	}
+
+	if !hub.Stop("c1", "paused") {
+		t.Fatal("Stop found no running room")
+	}
+	select {
+	case status := <-closed:
+		if status != "paused" {
+			t.Errorf("OnClose status = %q, want paused", status)
+		}
+	default:
+		t.Fatal("Stop returned before OnClose ran")
+	}
+	if hub.Get("c1") != nil || hub.Stop("c1", "") {
+		t.Error("room still registered after Stop")
+	}
+}
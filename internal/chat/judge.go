@@ -0,0 +1,361 @@
+package chat
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"qigent/internal/llm"
+	"sort"
+	"strings"
+)
+
+// JudgeName is the sender of the judge's messages.
+const JudgeName = "Judge"
+
+// Draw is the Verdict winner when nobody won.
+const Draw = "draw"
+
+// DefaultJudgePrompt is the judge persona used when a conversation configures none.
+const DefaultJudgePrompt = "你是一位公正、幽默的辩论裁判。请阅读以上辩论记录，对各方的表现进行点评，指出亮眼之处和逻辑漏洞，并最终判定胜负（或平局）。点评字数控制在500字以内。"
+
+// How a panel's ballots are combined.
+const (
+	AggregateMean     = "mean"     // Average each agent's scores (default)
+	AggregateMedian   = "median"   // Median of each agent's scores
+	AggregateMajority = "majority" // The winner most judges picked
+)
+
+// JudgeConfig configures how a conversation is judged.
+type JudgeConfig struct {
+	// Name labels this judge's ballot in a panel.
+	Name string `json:"name,omitempty"`
+	// Prompt is the judge persona. Role names the role-market entry it was
+	// taken from, if any; the prompt is copied so later role edits don't apply.
+	Prompt string `json:"prompt,omitempty"`
+	Role   string `json:"role,omitempty"`
+	// Profile names a provider profile for the judge (see data.Profile);
+	// Model overrides the conversation's (or the profile's) model.
+	Profile string `json:"profile,omitempty"`
+	Model   string `json:"model,omitempty"`
+	// Sampling is the judge's generation settings, e.g. temperature 0 for
+	// repeatable scores. Panelists don't inherit it.
+	Sampling llm.Sampling `json:"sampling"`
+	// Rubric lists the criteria each agent is scored on. Empty means a single overall score.
+	Rubric []Criterion `json:"rubric,omitempty"`
+
+	// Panel, if set, replaces the single judge with several judges that
+	// score in parallel. Panelists inherit Prompt and Rubric when they
+	// leave them empty. Aggregate combines their ballots.
+	Panel     []JudgeConfig `json:"panel,omitempty"`
+	Aggregate string        `json:"aggregate,omitempty"`
+}
+
+// Criterion is one rubric line, scored 0-10 per agent.
+type Criterion struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Weight      float64 `json:"weight,omitempty"` // Relative weight; 0 counts as 1
+}
+
+// Validate rejects unnamed, duplicate or negatively weighted criteria,
+// unknown aggregation methods and nested panels.
+func (j JudgeConfig) Validate() error {
+	switch j.Aggregate {
+	case "", AggregateMean, AggregateMedian, AggregateMajority:
+	default:
+		return fmt.Errorf("unknown aggregate method: %s", j.Aggregate)
+	}
+	for _, p := range j.Panel {
+		if len(p.Panel) > 0 {
+			return fmt.Errorf("judge panels cannot be nested")
+		}
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range j.Rubric {
+		if strings.TrimSpace(c.Name) == "" {
+			return fmt.Errorf("rubric criterion name is required")
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("duplicate rubric criterion: %s", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Weight < 0 {
+			return fmt.Errorf("rubric weight for %s must not be negative", c.Name)
+		}
+	}
+	return nil
+}
+
+// Panelist returns the effective config of the i-th panel judge.
+func (j JudgeConfig) Panelist(i int) JudgeConfig {
+	p := j.Panel[i]
+	if p.Name == "" {
+		p.Name = fmt.Sprintf("%s %d", JudgeName, i+1)
+	}
+	if p.Prompt == "" {
+		p.Prompt = j.Prompt
+	}
+	if len(p.Rubric) == 0 {
+		p.Rubric = j.Rubric
+	}
+	return p
+}
+
+// Verdict is the judge's structured decision. A panel's combined verdict
+// also carries the individual ballots and how they were aggregated.
+type Verdict struct {
+	Scores    []AgentScore `json:"scores"`
+	Winner    string       `json:"winner"` // An agent name, or Draw
+	Rationale string       `json:"rationale"`
+
+	Method  string   `json:"method,omitempty"`
+	Ballots []Ballot `json:"ballots,omitempty"`
+}
+
+// Ballot is one panel judge's result. Verdict is nil if the judge failed.
+type Ballot struct {
+	Judge   string   `json:"judge"`
+	Verdict *Verdict `json:"verdict,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// aggregate combines the ballots of a panel into one verdict. Ballots
+// without a verdict are kept for the record but not counted.
+func aggregate(method string, ballots []Ballot, agents []string) (Verdict, error) {
+	if method == "" {
+		method = AggregateMean
+	}
+	v := Verdict{Method: method, Ballots: ballots}
+
+	scores := make(map[string][]float64)
+	votes := make(map[string]int)
+	counted := 0
+	for _, b := range ballots {
+		if b.Verdict == nil {
+			continue
+		}
+		counted++
+		votes[b.Verdict.Winner]++
+		for _, s := range b.Verdict.Scores {
+			scores[s.Agent] = append(scores[s.Agent], s.Score)
+		}
+	}
+	if counted == 0 {
+		return v, fmt.Errorf("no judge returned a verdict")
+	}
+
+	for _, a := range agents {
+		list := scores[a]
+		if len(list) == 0 {
+			continue
+		}
+		score := mean(list)
+		if method == AggregateMedian {
+			score = median(list)
+		}
+		v.Scores = append(v.Scores, AgentScore{Agent: a, Score: math.Round(score*100) / 100})
+	}
+
+	if method == AggregateMajority {
+		v.Winner = majority(votes)
+		var tally []string
+		for _, a := range append(append([]string(nil), agents...), Draw) {
+			if votes[a] > 0 {
+				tally = append(tally, fmt.Sprintf("%s %d", a, votes[a]))
+			}
+		}
+		v.Rationale = fmt.Sprintf("%d of %d judges voted: %s.", counted, len(ballots), strings.Join(tally, ", "))
+	} else {
+		v.Winner = topScorer(v.Scores)
+		v.Rationale = fmt.Sprintf("%s score over %d of %d judges.", method, counted, len(ballots))
+	}
+	return v, nil
+}
+
+func mean(xs []float64) float64 {
+	var sum float64
+	for _, x := range xs {
+		sum += x
+	}
+	return sum / float64(len(xs))
+}
+
+func median(xs []float64) float64 {
+	sorted := append([]float64(nil), xs...)
+	sort.Float64s(sorted)
+	n := len(sorted)
+	if n%2 == 1 {
+		return sorted[n/2]
+	}
+	return (sorted[n/2-1] + sorted[n/2]) / 2
+}
+
+// majority returns the most voted winner, or Draw if the top is shared.
+func majority(votes map[string]int) string {
+	best, tied := "", false
+	for w, n := range votes {
+		switch {
+		case best == "" || n > votes[best]:
+			best, tied = w, false
+		case n == votes[best]:
+			tied = true
+		}
+	}
+	if tied {
+		return Draw
+	}
+	return best
+}
+
+// AgentScore is one agent's result. Score is 0-10; with a rubric it is the
+// weighted mean of Criteria.
+type AgentScore struct {
+	Agent    string             `json:"agent"`
+	Score    float64            `json:"score"`
+	Criteria map[string]float64 `json:"criteria,omitempty"`
+	Comment  string             `json:"comment,omitempty"`
+}
+
+// systemPrompt is the persona plus the rubric and the required output format.
+func (j JudgeConfig) systemPrompt(agents []string) string {
+	var sb strings.Builder
+	if j.Prompt != "" {
+		sb.WriteString(j.Prompt)
+	} else {
+		sb.WriteString(DefaultJudgePrompt)
+	}
+
+	fmt.Fprintf(&sb, "\n\nParticipants: %s.", strings.Join(agents, ", "))
+	criteria := `"score": <0-10>`
+	if len(j.Rubric) > 0 {
+		sb.WriteString("\nScore each participant from 0 to 10 on every criterion:")
+		var names []string
+		for _, c := range j.Rubric {
+			fmt.Fprintf(&sb, "\n- %s", c.Name)
+			if c.Description != "" {
+				sb.WriteString(": " + c.Description)
+			}
+			names = append(names, fmt.Sprintf("%q: <0-10>", c.Name))
+		}
+		criteria = `"criteria": {` + strings.Join(names, ", ") + `}`
+	}
+	fmt.Fprintf(&sb, "\n\nReply with JSON only, no code fences: "+
+		`{"scores": [{"agent": "<participant name>", %s, "comment": "<one sentence>"}], "winner": "<participant name or %s>", "rationale": "<your commentary>"}`,
+		criteria, Draw)
+	return sb.String()
+}
+
+// parseVerdict extracts the verdict from a judge reply and checks it against
+// the participants. Rubric scores are combined into Score, and a missing or
+// unknown winner is replaced by the top scorer.
+func (j JudgeConfig) parseVerdict(reply string, agents []string) (Verdict, error) {
+	var v Verdict
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return v, fmt.Errorf("judge reply has no JSON object: %q", reply)
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &v); err != nil {
+		return v, fmt.Errorf("invalid verdict JSON: %w", err)
+	}
+
+	known := make(map[string]bool)
+	for _, a := range agents {
+		known[a] = true
+	}
+	scores := v.Scores[:0]
+	for _, s := range v.Scores {
+		s.Agent = strings.TrimSpace(s.Agent)
+		if !known[s.Agent] {
+			continue
+		}
+		if len(j.Rubric) > 0 {
+			s.Score = j.weighted(s.Criteria)
+		}
+		s.Score = clampScore(s.Score)
+		scores = append(scores, s)
+	}
+	if len(scores) == 0 {
+		return v, fmt.Errorf("verdict scores no known participant")
+	}
+	v.Scores = scores
+
+	v.Winner = strings.TrimSpace(v.Winner)
+	if !known[v.Winner] && !strings.EqualFold(v.Winner, Draw) {
+		v.Winner = topScorer(scores)
+	} else if strings.EqualFold(v.Winner, Draw) {
+		v.Winner = Draw
+	}
+	v.Rationale = strings.TrimSpace(v.Rationale)
+	return v, nil
+}
+
+func (j JudgeConfig) weighted(criteria map[string]float64) float64 {
+	var sum, total float64
+	for _, c := range j.Rubric {
+		w := c.Weight
+		if w == 0 {
+			w = 1
+		}
+		sum += w * clampScore(criteria[c.Name])
+		total += w
+	}
+	if total == 0 {
+		return 0
+	}
+	return math.Round(sum/total*100) / 100
+}
+
+func clampScore(s float64) float64 {
+	return math.Max(0, math.Min(10, s))
+}
+
+// topScorer returns the agent with the highest score, or Draw on a tie.
+func topScorer(scores []AgentScore) string {
+	best, tied := -1, false
+	for i, s := range scores {
+		switch {
+		case best < 0 || s.Score > scores[best].Score:
+			best, tied = i, false
+		case s.Score == scores[best].Score:
+			tied = true
+		}
+	}
+	if tied {
+		return Draw
+	}
+	return scores[best].Agent
+}
+
+// Markdown renders the verdict for the conversation history.
+func (v Verdict) Markdown() string {
+	var sb strings.Builder
+	if v.Rationale != "" {
+		sb.WriteString(v.Rationale)
+		sb.WriteString("\n\n")
+	}
+	sb.WriteString("| Participant | Score | Comment |\n|---|---|---|\n")
+	for _, s := range v.Scores {
+		fmt.Fprintf(&sb, "| %s | %.1f | %s |\n", s.Agent, s.Score, s.Comment)
+	}
+	if len(v.Ballots) > 0 {
+		sb.WriteString("\nBallots:\n")
+		for _, b := range v.Ballots {
+			if b.Verdict == nil {
+				fmt.Fprintf(&sb, "- %s: no verdict (%s)\n", b.Judge, b.Error)
+			} else {
+				fmt.Fprintf(&sb, "- %s: %s\n", b.Judge, b.Verdict.Winner)
+			}
+		}
+	}
+	if v.Winner == Draw {
+		sb.WriteString("\n**Result: draw**")
+	} else {
+		fmt.Fprintf(&sb, "\n**Winner: %s**", v.Winner)
+	}
+	return sb.String()
+}
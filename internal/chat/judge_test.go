@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseVerdictRubric(t *testing.T) {
+	j := JudgeConfig{Rubric: []Criterion{{Name: "logic", Weight: 3}, {Name: "style"}}}
+	reply := "```json\n" + `{"scores": [
+		{"agent": "A", "criteria": {"logic": 8, "style": 4}},
+		{"agent": "B", "criteria": {"logic": 6, "style": 10}},
+		{"agent": "Nobody", "score": 10}
+	], "winner": "someone else", "rationale": "Close."}` + "\n```"
+
+	v, err := j.parseVerdict(reply, []string{"A", "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Scores) != 2 {
+		t.Fatalf("scores = %+v, want unknown agents dropped", v.Scores)
+	}
+	if v.Scores[0].Score != 7 || v.Scores[1].Score != 7 {
+		t.Fatalf("weighted scores = %v, %v, want 7 and 7", v.Scores[0].Score, v.Scores[1].Score)
+	}
+	if v.Winner != Draw {
+		t.Fatalf("winner = %q, want the tie to be a draw", v.Winner)
+	}
+}
+
+func TestParseVerdictKeepsWinner(t *testing.T) {
+	v, err := JudgeConfig{}.parseVerdict(`{"scores": [{"agent": "A", "score": 12}, {"agent": "B", "score": 9}], "winner": "B"}`, []string{"A", "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Winner != "B" || v.Scores[0].Score != 10 {
+		t.Fatalf("verdict = %+v, want winner B and clamped scores", v)
+	}
+	if _, err := (JudgeConfig{}).parseVerdict("A wins!", []string{"A"}); err == nil {
+		t.Fatal("expected error for a reply without JSON")
+	}
+}
+
+func TestJudgePromptListsRubric(t *testing.T) {
+	j := JudgeConfig{Prompt: "Be strict.", Rubric: []Criterion{{Name: "evidence", Description: "uses sources"}}}
+	prompt := j.systemPrompt([]string{"A", "B"})
+	for _, want := range []string{"Be strict.", "- evidence: uses sources", `"evidence": <0-10>`} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt missing %q:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	ballot := func(winner string, a, b float64) Ballot {
+		return Ballot{Verdict: &Verdict{Winner: winner, Scores: []AgentScore{{Agent: "A", Score: a}, {Agent: "B", Score: b}}}}
+	}
+	ballots := []Ballot{ballot("A", 9, 2), ballot("B", 4, 6), ballot("B", 5, 7), {Judge: "J4", Error: "timeout"}}
+	agents := []string{"A", "B"}
+
+	v, err := aggregate(AggregateMean, ballots, agents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Scores[0].Score != 6 || v.Scores[1].Score != 5 || v.Winner != "A" {
+		t.Fatalf("mean verdict = %+v", v)
+	}
+	if len(v.Ballots) != 4 {
+		t.Fatalf("got %d ballots, want failed ones kept", len(v.Ballots))
+	}
+
+	v, _ = aggregate(AggregateMedian, ballots, agents)
+	if v.Scores[0].Score != 5 || v.Scores[1].Score != 6 || v.Winner != "B" {
+		t.Fatalf("median verdict = %+v", v)
+	}
+
+	v, _ = aggregate(AggregateMajority, ballots, agents)
+	if v.Winner != "B" {
+		t.Fatalf("majority winner = %q, want B", v.Winner)
+	}
+
+	if _, err := aggregate(AggregateMean, []Ballot{{Error: "x"}}, agents); err == nil {
+		t.Fatal("expected error when no judge returned a verdict")
+	}
+}
@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"qigent/internal/agent"
+	"qigent/internal/llm"
+	"strings"
+)
+
+// ModeratorName is the sender used for the topic seed and for moderators without a name.
+const ModeratorName = "Moderator"
+
+// DefaultModeratorPrompt is used when a moderator is configured without its own prompt.
+const DefaultModeratorPrompt = "You are the moderator of a multi-party discussion. Keep it focused, give quieter participants room to speak, and end it once the topic is exhausted."
+
+// defaultModeratorWindow is how many recent history messages the moderator reads.
+const defaultModeratorWindow = 12
+
+// ModeratorDecision is the structured output the moderator returns before each turn.
+type ModeratorDecision struct {
+	Next     string `json:"next"`               // Name of the agent that should speak next
+	Message  string `json:"message,omitempty"`  // Optional short steering message, shown to everyone
+	Finished bool   `json:"finished,omitempty"` // Set when the discussion should end
+	Reason   string `json:"reason,omitempty"`   // Why the discussion ended, if Finished
+}
+
+// Moderator is an LLM participant that picks the next speaker.
+// It does not take turns itself; it only steers.
+type Moderator struct {
+	Agent  *agent.Agent
+	Window int // Recent history messages to read; 0 means defaultModeratorWindow
+}
+
+// NewModerator wraps ag as a room moderator.
+func NewModerator(ag *agent.Agent) *Moderator {
+	return &Moderator{Agent: ag}
+}
+
+// Name returns the sender name used for the moderator's messages.
+func (m *Moderator) Name() string {
+	if m.Agent == nil || m.Agent.Name == "" {
+		return ModeratorName
+	}
+	return m.Agent.Name
+}
+
+// Decide asks the moderator who should speak next.
+// The returned index is -1 if the discussion is finished.
+func (m *Moderator) Decide(ctx context.Context, agents []*agent.Agent, history []Message) (ModeratorDecision, int, error) {
+	window := m.Window
+	if window <= 0 {
+		window = defaultModeratorWindow
+	}
+	recent := history
+	if len(recent) > window {
+		recent = recent[len(recent)-window:]
+	}
+
+	var names []string
+	for _, ag := range agents {
+		names = append(names, ag.Name)
+	}
+
+	// The transcript, then the instruction as a speaker-less user turn.
+	prompt := transcript(recent)
+	prompt = append(prompt, llm.Turn{Content: fmt.Sprintf(
+		"Participants: %s. Decide who should speak next. "+
+			`Reply with JSON only: {"next": "<participant name>", "message": "<optional one-sentence steer, or empty>", "finished": <true if the discussion should end>, "reason": "<why it ended, if finished>"}`,
+		strings.Join(names, ", "))})
+
+	reply, err := m.Agent.Speak(ctx, prompt)
+	if err != nil {
+		return ModeratorDecision{}, -1, err
+	}
+
+	d, err := parseModeratorDecision(reply)
+	if err != nil {
+		return ModeratorDecision{}, -1, err
+	}
+	if d.Finished {
+		return d, -1, nil
+	}
+
+	idx := agentIndex(agents, d.Next)
+	if idx < 0 {
+		return d, -1, fmt.Errorf("moderator picked unknown speaker %q", d.Next)
+	}
+	return d, idx, nil
+}
+
+// parseModeratorDecision extracts the JSON object from a model reply,
+// tolerating code fences or chatter around it.
+func parseModeratorDecision(reply string) (ModeratorDecision, error) {
+	var d ModeratorDecision
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return d, fmt.Errorf("moderator reply has no JSON object: %q", reply)
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &d); err != nil {
+		return d, fmt.Errorf("invalid moderator JSON: %w", err)
+	}
+	d.Next = strings.TrimSpace(d.Next)
+	d.Message = strings.TrimSpace(d.Message)
+	return d, nil
+}
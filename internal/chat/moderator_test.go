@@ -0,0 +1,18 @@
+package chat
+
+import "testing"
+
+func TestParseModeratorDecision(t *testing.T) {
+	reply := "Sure.\n```json\n{\"next\": \" Bob \", \"message\": \"Stay on topic.\"}\n```"
+	d, err := parseModeratorDecision(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Next != "Bob" || d.Message != "Stay on topic." || d.Finished {
+		t.Fatalf("unexpected decision: %+v", d)
+	}
+
+	if _, err := parseModeratorDecision("Bob should go next"); err == nil {
+		t.Fatal("expected error for reply without JSON")
+	}
+}
@@ -1,334 +1,1119 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"qigent/internal/agent"
 	"qigent/internal/llm"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 )
 
 // Room manages the agents and the conversation loop.
+//
+// The exported fields configure the room before StartLoop. After that they
+// belong to the room's owner goroutine: read them with Snapshot and change
+// them with the room's methods, which go through its mailbox.
 type Room struct {
-	Agents    []*agent.Agent
-	History   []Message
-	Broadcast chan Message
-	InputChan chan Message // Channel for external (user) injection
-	Stop      chan struct{}
+	Agents  []*agent.Agent
+	History []Message
+	// Topic opens every prompt, pinned. StartLoop sets it from its topic
+	// if empty.
+	Topic string
+
+	Events *Bus // Everything the room says, in order, for any number of subscribers
+	// Stop is closed by StopLoop. It bypasses the mailbox so it can cut
+	// off an LLM call in flight.
+	Stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{} // Closed when the owner goroutine exits
+
+	// Policy picks the next speaker. Defaults to round-robin.
+	Policy TurnPolicy
+	// Moderator, if set, picks the next speaker instead of Policy
+	// (Policy remains the fallback when the moderator fails).
+	Moderator *Moderator
+
+	// Rules end the conversation automatically; Usage is measured against them.
+	Rules StopRules
+	Usage Usage
+	// StopReason records which rule (or the moderator) ended the conversation.
+	StopReason string
+	// NameStyle controls how speaker names are shown to the judge and classifiers.
+	NameStyle llm.NameStyle
+
+	// JudgeClient is used by stop rules for judging and consensus checks,
+	// and for summarizing. Defaults to the first agent's client.
+	JudgeClient llm.Provider
+
+	// JudgeConfig sets the judge's persona and rubric; Verdict is its
+	// result, once judged.
+	JudgeConfig JudgeConfig
+	Verdict     *Verdict
+	// PanelClients are the clients of JudgeConfig.Panel, by index;
+	// nil entries use the judge client.
+	PanelClients []llm.Provider
+
+	// Format, if set, schedules the speakers phase by phase instead of
+	// Policy and Moderator. FormatStep is the position in its schedule.
+	Format     *Format
+	FormatStep int
+	// Phase is the name of the current format phase, if any.
+	Phase string
+	// NextSpeaker, if set, is the agent that speaks next whatever Policy
+	// and Moderator say: the speaker of a regenerated turn.
+	NextSpeaker string
+
+	// Summary replaces older turns in every prompt. It is refreshed every
+	// SummaryEvery agent turns (0 disables summarizing).
+	Summary      Summary
+	SummaryEvery int
+
+	// Retry sets how a failed turn request is retried before the room moves
+	// on to the next speaker; nil means llm.DefaultRetry.
+	Retry *llm.RetryPolicy
+
+	mailbox   chan command // Requests for the owner goroutine
+	judgeReqs []command    // Judge requests waiting for the conversation to wind down
+
+	pauseMu sync.Mutex
+	resumed chan struct{} // Non-nil while paused; closed by Resume
+	cutTurn chan struct{} // Signalled by an immediate Pause to end the current turn
 }
 
 // NewRoom creates a new chat room with the given agents.
 func NewRoom(agents []*agent.Agent) *Room {
 	return &Room{
-		Agents:    agents,
-		Broadcast: make(chan Message),
-		InputChan: make(chan Message), // Buffered?
-		Stop:      make(chan struct{}),
+		Agents:  agents,
+		Events:  NewBus(),
+		Stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		cutTurn: make(chan struct{}, 1),
+		mailbox: make(chan command, mailboxSize),
+		Policy:  RoundRobin{},
 	}
 }
 
+// mailboxSize lets callers queue a few commands while the owner is busy
+// (e.g. waiting on the moderator) instead of blocking.
+const mailboxSize = 16
+
+// command is a request for the owner goroutine. The owner serves the
+// mailbox between turns, while streaming, while paused and after the
+// conversation has ended, so commands never race with a turn.
+type command struct {
+	kind  string // "inject", "judge", "edit", "delete", "regenerate", "snapshot"
+	msg   Message
+	id    string
+	text  string
+	snap  *Snapshot // Filled in by "snapshot"
+	reply chan error
+}
+
 // InjectMessage allows external injection of a message into the flow
 func (r *Room) InjectMessage(msg Message) {
-	// Push to InputChan. Ideally this should be non-blocking or guarded?
-	// But since StartLoop consumes it, we can send it there.
 	select {
-	case r.InputChan <- msg:
+	case r.mailbox <- command{kind: "inject", msg: msg}:
+	case <-r.done:
+		log.Println("Warn: InjectMessage on a closed room")
 	case <-time.After(500 * time.Millisecond):
 		log.Println("Warn: InjectMessage timed out (Room busy or stopped)")
 	}
 }
 
-// StartLoop begins the conversation loop.
-func (r *Room) StartLoop(initialTopic string) {
+// ErrRoomStopped is returned by room commands once the owner has exited.
+var ErrRoomStopped = errors.New("room is not running")
+
+// ErrNoJudge is returned by Judge when the room has no model to judge with.
+var ErrNoJudge = errors.New("no judge model")
+
+// EditMessage replaces the text of a stored message; the next turn sees the edit.
+func (r *Room) EditMessage(ctx context.Context, id, text string) error {
+	return r.submit(ctx, command{kind: "edit", id: id, text: text})
+}
+
+// DeleteMessage removes a stored message from the history.
+func (r *Room) DeleteMessage(ctx context.Context, id string) error {
+	return r.submit(ctx, command{kind: "delete", id: id})
+}
+
+// Regenerate drops the last agent turn and has the same agent speak again
+// with the same context. A turn that is still streaming is discarded first.
+func (r *Room) Regenerate(ctx context.Context) error {
+	return r.submit(ctx, command{kind: "regenerate"})
+}
+
+// Judge ends the conversation, cutting off the current turn, and has the
+// judge give its verdict (see judge). It returns once the verdict is in.
+func (r *Room) Judge(ctx context.Context) error {
+	return r.submit(ctx, command{kind: "judge"})
+}
+
+// Snapshot is a consistent copy of the room's state: for a client catching
+// up, the history, the turn being streamed (if any) so far, and the sequence
+// number of the last event they reflect; for persistence, the rest.
+type Snapshot struct {
+	History []Message `json:"history"`
+	Partial *Message  `json:"partial,omitempty"`
+	Seq     uint64    `json:"seq"`
+
+	Usage      Usage                   `json:"-"`
+	StopReason string                  `json:"-"`
+	Summary    Summary                 `json:"-"`
+	FormatStep int                     `json:"-"`
+	Phase      string                  `json:"-"`
+	Verdict    *Verdict                `json:"-"`
+	Notes      map[string][]agent.Note `json:"-"` // Private to each agent; never sent to clients
+
+	NextSpeaker string `json:"-"`
+}
+
+// Snapshot takes a Snapshot on the owner goroutine. Once the owner has
+// exited the state is final and is read directly.
+func (r *Room) Snapshot(ctx context.Context) (Snapshot, error) {
+	var snap Snapshot
+	err := r.submit(ctx, command{kind: "snapshot", snap: &snap})
+	if errors.Is(err, ErrRoomStopped) {
+		return r.snapshot(), nil
+	}
+	return snap, err
+}
+
+// snapshot copies the state. Only the owner (or anyone, once it has exited) may call it.
+func (r *Room) snapshot() Snapshot {
+	notes := make(map[string][]agent.Note)
+	for _, ag := range r.Agents {
+		if len(ag.Notes) > 0 {
+			notes[ag.Name] = slices.Clone(ag.Notes)
+		}
+	}
+	var verdict *Verdict
+	if r.Verdict != nil {
+		v := *r.Verdict
+		verdict = &v
+	}
+	return Snapshot{
+		History:    slices.Clone(r.History),
+		Seq:        r.Events.Seq(),
+		Usage:      r.Usage,
+		StopReason: r.StopReason,
+		Summary:    r.Summary,
+		FormatStep: r.FormatStep,
+		Phase:      r.Phase,
+		Verdict:    verdict,
+		Notes:      notes,
+
+		NextSpeaker: r.NextSpeaker,
+	}
+}
+
+// progress and setProgress hand the room's state to a Progress edit and
+// take it back. Owner only.
+func (r *Room) progress() Progress {
+	notes := make(map[string][]agent.Note, len(r.Agents))
+	for _, ag := range r.Agents {
+		notes[ag.Name] = ag.Notes
+	}
+	return Progress{History: r.History, Summary: r.Summary, FormatStep: r.FormatStep, NextSpeaker: r.NextSpeaker, Notes: notes}
+}
+
+func (r *Room) setProgress(p Progress) {
+	r.History, r.Summary, r.FormatStep, r.NextSpeaker = p.History, p.Summary, p.FormatStep, p.NextSpeaker
+	for _, ag := range r.Agents {
+		ag.Notes = p.Notes[ag.Name]
+	}
+}
+
+func (r *Room) submit(ctx context.Context, cmd command) error {
+	cmd.reply = make(chan error, 1)
+	select {
+	case r.mailbox <- cmd:
+	case <-r.done:
+		return ErrRoomStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-cmd.reply:
+		return err
+	case <-r.done:
+		// The owner exited with the command still queued.
+		return ErrRoomStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handle runs a command on the owner goroutine and tells clients about any
+// change. Judge requests are queued for when the conversation has wound
+// down; the caller checks judgeReqs.
+func (r *Room) handle(ctx context.Context, cmd command) {
+	var err error
+	switch cmd.kind {
+	case "inject":
+		r.recordIntervention(ctx, cmd.msg)
+		return
+	case "judge":
+		if r.judgeClient() == nil {
+			cmd.reply <- ErrNoJudge
+			return
+		}
+		r.judgeReqs = append(r.judgeReqs, cmd)
+		return
+	case "edit":
+		r.History, err = EditMessage(r.History, cmd.id, cmd.text)
+		if err == nil {
+			i := indexOfID(r.History, cmd.id)
+			r.emit(ctx, Message{ID: cmd.id, Sender: r.History[i].Sender, Content: r.History[i].Content, Type: EventEdit})
+		}
+	case "delete":
+		p := r.progress()
+		if err = p.Delete(cmd.id); err == nil {
+			r.setProgress(p)
+			r.emit(ctx, Message{ID: cmd.id, Sender: "System", Type: EventDelete})
+		}
+	case "regenerate":
+		p := r.progress()
+		var removed Message
+		if removed, err = p.Regenerate(agentNames(r.Agents)); err == nil {
+			r.setProgress(p)
+			r.emit(ctx, Message{ID: removed.ID, Sender: "System", Type: EventDelete})
+			log.Printf("Regenerating turn of %s", removed.Sender)
+		}
+	case "snapshot":
+		*cmd.snap = r.snapshot()
+	}
+	cmd.reply <- err
+}
+
+// Pause holds the loop before the next turn. With immediate set, the current
+// speaker is cut off (its partial turn is kept, marked "[Paused]"); otherwise
+// it is allowed to finish. Pausing a paused room is a no-op.
+func (r *Room) Pause(immediate bool) {
+	r.pauseMu.Lock()
+	defer r.pauseMu.Unlock()
+	if r.resumed != nil {
+		return
+	}
+	r.resumed = make(chan struct{})
+	if immediate {
+		select {
+		case r.cutTurn <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Resume lets a paused loop continue with the next speaker.
+func (r *Room) Resume() {
+	r.pauseMu.Lock()
+	defer r.pauseMu.Unlock()
+	if r.resumed == nil {
+		return
+	}
+	close(r.resumed)
+	r.resumed = nil
+	// A cut signal no turn has taken yet is stale now; left in place it
+	// would cut off the next speaker as soon as it starts.
+	select {
+	case <-r.cutTurn:
+	default:
+	}
+}
+
+// Paused reports whether the room is paused (or will pause after the current turn).
+func (r *Room) Paused() bool {
+	r.pauseMu.Lock()
+	defer r.pauseMu.Unlock()
+	return r.resumed != nil
+}
+
+func (r *Room) pausedChan() <-chan struct{} {
+	r.pauseMu.Lock()
+	defer r.pauseMu.Unlock()
+	return r.resumed
+}
+
+// waitWhilePaused blocks while the room is paused, still serving the
+// mailbox. Returns false if ctx ended or the judge was asked for first.
+func (r *Room) waitWhilePaused(ctx context.Context) bool {
+	resumed := r.pausedChan()
+	if resumed == nil {
+		return true
+	}
+
+	log.Println("Room paused")
+	r.emit(ctx, Message{Sender: "System", Content: "paused", Type: EventStatus})
+	for {
+		select {
+		case <-resumed:
+			log.Println("Room resumed")
+			r.emit(ctx, Message{Sender: "System", Content: "active", Type: EventStatus})
+			return true
+		case cmd := <-r.mailbox:
+			r.handle(ctx, cmd)
+			if len(r.judgeReqs) > 0 {
+				return false
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// drain handles every queued command without waiting. It returns false if
+// the judge was asked for, which ends the conversation.
+func (r *Room) drain(ctx context.Context) bool {
+	for {
+		select {
+		case cmd := <-r.mailbox:
+			r.handle(ctx, cmd)
+		default:
+			return len(r.judgeReqs) == 0
+		}
+	}
+}
+
+// openTurn starts ag's turn and its stream, retrying failed requests per
+// r.Retry. Between attempts the turn is withdrawn and the mailbox served.
+// Once retries are exhausted it reports a typed error and returns a nil
+// stream, so the room moves on; ok is false if the room should stop.
+func (r *Room) openTurn(ctx context.Context, ag *agent.Agent, turnID string, turns []llm.Turn) (stream *llm.Stream, cancel context.CancelFunc, ok bool) {
+	policy := llm.DefaultRetry
+	if r.Retry != nil {
+		policy = *r.Retry
+	}
+	for attempt := 0; ; attempt++ {
+		if !r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Type: EventTurnStart}) {
+			return nil, nil, false
+		}
+
+		// Each turn gets its own context so an interruption can
+		// abort the upstream request without stopping the room.
+		turnCtx, cancelTurn := context.WithCancel(ctx)
+		stream, err := ag.SpeakStream(turnCtx, turns)
+		if err == nil {
+			return stream, cancelTurn, true
+		}
+		cancelTurn()
+		r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Type: EventTurnEnd})
+		if ctx.Err() != nil {
+			return nil, nil, false
+		}
+
+		if !llm.Retryable(err) {
+			r.emitError(ctx, turnID, ag.Name, err, attempt+1)
+			// Nothing will change by retrying; don't spin through the speakers.
+			return nil, nil, r.sleep(ctx, 2*time.Second)
+		}
+		if attempt >= policy.MaxRetries {
+			r.emitError(ctx, turnID, ag.Name, err, attempt+1)
+			return nil, nil, true
+		}
+		delay := policy.Delay(attempt, err)
+		log.Printf("Agent %s request failed (%v), retrying in %s", ag.Name, err, delay)
+		r.emit(ctx, Message{ID: turnID, Sender: "System", Type: EventDelete})
+		r.emit(ctx, Message{
+			Sender:  "System",
+			Content: fmt.Sprintf("%s: %s, retrying in %s (%d/%d)", ag.Name, llm.Classify(err), delay.Round(time.Second), attempt+1, policy.MaxRetries),
+			Type:    EventSystem,
+		})
+		if !r.sleep(ctx, delay) {
+			return nil, nil, false
+		}
+	}
+}
+
+// ErrorEvent is the content of an EventError: what failed, classified.
+type ErrorEvent struct {
+	Kind     llm.ErrorKind `json:"kind,omitempty"` // Empty if the error isn't a failed request
+	Message  string        `json:"message"`
+	Attempts int           `json:"attempts,omitempty"` // Requests made before giving up
+}
+
+// emitError publishes err as an EventError from sender.
+func (r *Room) emitError(ctx context.Context, id, sender string, err error, attempts int) {
+	data, _ := json.Marshal(ErrorEvent{Kind: llm.Classify(err), Message: err.Error(), Attempts: attempts})
+	r.emit(ctx, Message{ID: id, Sender: sender, Content: string(data), Type: EventError})
+}
+
+// sleep waits d between turns while serving the mailbox. It returns false
+// if ctx ended or the judge was asked for first.
+func (r *Room) sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			return true
+		case cmd := <-r.mailbox:
+			r.handle(ctx, cmd)
+			if len(r.judgeReqs) > 0 {
+				return false
+			}
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// idle serves the mailbox once the conversation has ended, until ctx is done.
+func (r *Room) idle(ctx context.Context) {
+	for {
+		select {
+		case cmd := <-r.mailbox:
+			switch cmd.kind {
+			case "inject":
+				log.Printf("Dropped message to a finished room: %s", cmd.msg.Content)
+			case "regenerate":
+				// No turn will run to take it again.
+				cmd.reply <- ErrRoomStopped
+			case "judge":
+				cmd.reply <- r.judgeOnRequest(ctx)
+			default:
+				r.handle(ctx, cmd)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordIntervention shows a user message and adds it to History so the next agent sees it.
+func (r *Room) recordIntervention(ctx context.Context, userMsg Message) {
+	log.Printf("User injected message: %s", userMsg.Content)
+	userMsg.ID = NewID()
+	// 1. Broadcast to UI so it shows up immediately (as User)
+	r.emit(ctx, Message{ID: userMsg.ID, Sender: userMsg.Sender, Content: userMsg.Content, Type: EventIntervention})
+
+	// 2. Add to History so Agent will see it
+	// Format: "User: content"
+	r.record(Message{
+		ID:      userMsg.ID,
+		Sender:  userMsg.Sender, // "User"
+		Content: userMsg.Content,
+		Type:    EventMessage, // Treat as a full message
+	})
+
+	r.emit(ctx, Message{Sender: "System", Content: "User intervened.", Type: EventSystem})
+}
+
+// record appends msg to History, assigning an ID if it has none.
+func (r *Room) record(msg Message) Message {
+	if msg.ID == "" {
+		msg.ID = NewID()
+	}
+	r.History = append(r.History, msg)
+	return msg
+}
+
+// emit publishes msg on the event bus. Publishing never blocks; it returns
+// false (and publishes nothing) once ctx is done, so callers can bail out.
+func (r *Room) emit(ctx context.Context, msg Message) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	r.Events.Publish(msg)
+	return true
+}
+
+// StartLoop starts the room's owner goroutine, which runs the conversation
+// and is the only goroutine that touches the room's state; everything else
+// reaches it through the mailbox (see command). Once the conversation ends
+// (StopLoop, a stop rule, or the judge) the owner keeps serving the mailbox,
+// so a finished room can still be judged, edited and snapshotted, until ctx
+// is cancelled. A room stopped before StartLoop only serves the mailbox.
+func (r *Room) StartLoop(ctx context.Context, initialTopic string) {
 	log.Printf("Room StartLoop: Conversation started on topic: %s", initialTopic)
+	if r.Topic == "" {
+		r.Topic = initialTopic
+	}
 
-	// Seed history with topic if provided and if history is empty
-	// (Check added to avoid dupes if re-starting logic changed, though strict empty check is usually fine)
-	var initialHistory []string
+	// Derive a loop context that is also cancelled by StopLoop, so a single
+	// Done() channel covers stop, disconnect and judge. The parent outlives
+	// StopLoop and is used when a stop rule hands over to the judge.
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-r.Stop:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	go func() {
+		defer close(r.done)
+		select {
+		case <-r.Stop:
+			// Stopped before it started, e.g. a finished conversation
+			// reopened for viewing: don't run it again.
+		default:
+			r.converse(ctx, parent, initialTopic)
+		}
+		cancel()
+		if len(r.judgeReqs) > 0 {
+			err := r.judgeOnRequest(parent)
+			for _, cmd := range r.judgeReqs {
+				cmd.reply <- err
+			}
+			r.judgeReqs = nil
+		}
+		r.idle(parent)
+	}()
+}
+
+// converse runs the conversation until ctx ends, a stop rule fires, or the
+// judge is asked for. parent outlives ctx and is used to conclude.
+func (r *Room) converse(ctx, parent context.Context, initialTopic string) {
+	moderatorName := ModeratorName
+	if r.Moderator != nil {
+		moderatorName = r.Moderator.Name()
+	}
+
+	// Seed history with topic if provided. The seed is a real moderator
+	// message, so it is persisted and visible like any other turn.
 	if initialTopic != "" {
-		initialHistory = append(initialHistory, "Moderator: Please discuss the topic: "+initialTopic)
+		seed := r.record(Message{
+			Sender:  moderatorName,
+			Content: seedText(initialTopic),
+			Type:    EventMessage,
+		})
+		r.emit(ctx, Message{ID: seed.ID, Sender: moderatorName, Content: seed.Content, Type: EventMessage})
 	}
 
-	go func() {
-		// TURN RECOVERY LOGIC
-		startIndex := 0
-		if len(r.History) > 0 {
-			// Search backwards avoiding "System", "User", "Judge"
-			found := false
-			for j := len(r.History) - 1; j >= 0; j-- {
-				lastMsg := r.History[j]
-				// Check if this sender matches any of our agents
-				for k, ag := range r.Agents {
-					if ag.Name == lastMsg.Sender {
-						// This agent spoke last, so start with the NEXT one
-						startIndex = (k + 1) % len(r.Agents)
-						log.Printf("Resuming conversation. Last speaker found: %s. Next speaker: %s", ag.Name, r.Agents[startIndex].Name)
-						found = true
-						break
-					}
+	policy := r.Policy
+	if policy == nil {
+		policy = RoundRobin{}
+	}
+
+	// TURN RECOVERY LOGIC: the policy continues from whoever spoke last.
+	last := LastSpeaker(r.Agents, r.History)
+	if last >= 0 {
+		log.Printf("Resuming conversation. Last speaker found: %s", r.Agents[last].Name)
+	} else if len(r.History) > 0 {
+		log.Println("Resuming conversation but no previous agent speaker found.")
+	}
+
+	// Conversations saved before usage tracking: count turns from history.
+	if r.Usage.Turns == 0 {
+		for _, h := range r.History {
+			if agentIndex(r.Agents, h.Sender) >= 0 {
+				r.Usage.Turns++
+			}
+		}
+	}
+	started := time.Now()
+	baseElapsed := r.Usage.ElapsedSec
+	sinceConsensus := 0
+	sinceSummary := 0
+	phaseAnnounced := false // Tell (re)connected clients the phase once per start
+
+	for {
+		// Check for stop
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Paused time doesn't count towards the duration limit.
+		pauseStart := time.Now()
+		if !r.waitWhilePaused(ctx) {
+			return
+		}
+		started = started.Add(time.Since(pauseStart))
+
+		// Stop rules are evaluated between turns.
+		r.Usage.ElapsedSec = baseElapsed + time.Since(started).Seconds()
+		if reason := r.Rules.exceeded(r.Usage); reason != "" {
+			r.conclude(parent, reason)
+			return
+		}
+		if r.Rules.Consensus && sinceConsensus >= len(r.Agents) {
+			sinceConsensus = 0
+			if client := r.judgeClient(); client != nil {
+				done, why, err := checkConsensus(ctx, client, r.promptTurns(), r.NameStyle)
+				if ctx.Err() != nil {
+					return
 				}
-				if found {
-					break
+				if err != nil {
+					log.Printf("Consensus check failed: %v", err)
+				} else if done {
+					r.conclude(parent, "consensus: "+why)
+					return
 				}
 			}
-			if !found {
-				log.Println("Resuming conversation but no previous agent speaker found. Starting from 0.")
+		}
+
+		if r.SummaryEvery > 0 && sinceSummary >= r.SummaryEvery {
+			sinceSummary = 0
+			if client := r.judgeClient(); client != nil {
+				changed, err := r.updateSummary(ctx, client)
+				if ctx.Err() != nil {
+					return
+				}
+				if err != nil {
+					log.Printf("Summary update failed: %v", err)
+				} else if changed {
+					r.emit(ctx, Message{ID: r.Summary.Through, Sender: "System", Content: r.Summary.Text, Type: EventSummary})
+				}
 			}
 		}
 
+		// CHECK FOR USER INJECTION BEFORE AGENT SPEAKS
+		if !r.drain(ctx) {
+			return
+		}
+
+		// Pick the speaker after any injection so policies can react to it
+		idx := -1
+		var phase *Phase
+		next := agentIndex(r.Agents, r.NextSpeaker)
+		r.NextSpeaker = ""
+		if r.Format != nil {
+			// The format's schedule decides; a regenerated turn has
+			// already been rewound to the same step.
+			p, name, ok := r.Format.At(r.FormatStep, agentNames(r.Agents))
+			if !ok {
+				r.conclude(parent, "format complete")
+				return
+			}
+			phase = &r.Format.Phases[p]
+			if phase.Name != r.Phase || !phaseAnnounced {
+				r.Phase = phase.Name
+				phaseAnnounced = true
+				r.emit(ctx, Message{Sender: "System", Content: phase.Name, Type: EventPhase})
+			}
+			idx = agentIndex(r.Agents, name)
+		} else if next >= 0 {
+			// Regenerating: the same agent speaks again.
+			idx = next
+		} else if r.Moderator != nil {
+			decision, next, err := r.Moderator.Decide(ctx, r.Agents, r.History)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("Moderator error, falling back to turn policy: %v", err)
+			} else {
+				if decision.Message != "" {
+					steer := r.record(Message{
+						Sender:  moderatorName,
+						Content: decision.Message,
+						Type:    EventMessage,
+					})
+					r.emit(ctx, Message{ID: steer.ID, Sender: moderatorName, Content: decision.Message, Type: EventMessage})
+				}
+				if decision.Finished {
+					r.conclude(parent, "moderator: "+decision.Reason)
+					return
+				}
+				idx = next
+			}
+		}
+		if idx < 0 {
+			idx = policy.Next(r.Agents, r.History, last)
+		}
+		last = idx
+		ag := r.Agents[idx]
+
+		log.Printf("Agent %s is thinking...", ag.Name)
+
+		// Prepare history: structured turns, mapped onto roles by the agent
+		turns := r.promptTurns()
+		if phase != nil {
+			turns = append(turns, phase.instruction())
+		}
+
+		// The turn's ID is shared by its start/chunk/end events and the history entry.
+		turnID := NewID()
+
+		stream, cancelTurn, ok := r.openTurn(ctx, ag, turnID, turns)
+		if !ok {
+			return
+		}
+		if stream == nil {
+			continue // Gave up on this speaker
+		}
+
+		var fullContentBuilder strings.Builder
+		var interrupted, discarded bool
+		// How the stream ended, if it ended by itself.
+		var result llm.Result
+		// Private notes are cut out of the stream before anyone sees it.
+		notes := ag.NoteFilter()
+
+		// Manual Loop for Select
+	loop:
 		for {
 			select {
-			case <-r.Stop:
+			case <-ctx.Done():
+				log.Printf("Agent %s loop stopped", ag.Name)
+				cancelTurn()
+				_, written := notes.Close()
+				ag.Remember(turnID, written...)
+				// Save partial content
+				partialContent := fullContentBuilder.String() + " [Paused]"
+				r.record(Message{
+					ID:      turnID,
+					Sender:  ag.Name,
+					Content: partialContent,
+					Type:    EventMessage,
+				})
 				return
-			default:
-				// Use a loop that we can offset
-				for i := 0; i < len(r.Agents); i++ {
-					// Calculate actual index based on offset
-					idx := (startIndex + i) % len(r.Agents)
-					ag := r.Agents[idx]
-
-					// Check for stop
-					select {
-					case <-r.Stop:
-						return
-					default:
-					}
-
-					// CHECK FOR USER INJECTION BEFORE AGENT SPEAKS
-					select {
-					case userMsg := <-r.InputChan:
-						log.Printf("User injected message: %s", userMsg.Content)
-						// 1. Broadcast to UI so it shows up immediately (as User)
-						r.Broadcast <- userMsg
-
-						// 2. Add to History so Agent will see it
-						// Format: "User: content"
-						r.History = append(r.History, Message{
-							Sender:  userMsg.Sender, // "User"
-							Content: "User (Intervention): " + userMsg.Content,
-							Type:    "full", // Treat as a full message
-						})
-
-						// Also broadcast "full" type for UI persistence if needed?
-						// Actually r.Broadcast <- userMsg handles the UI bubble.
-						// But we might want to save it.
-						r.Broadcast <- Message{Sender: "System", Content: "User intervened.", Type: "system"}
-
-						// Add to next turn's prompt context? handled by r.History loop below
-
-					default:
-						// No input, proceed
-					}
-
-					log.Printf("Agent %s is thinking...", ag.Name)
-
-					// Prepare history
-					var histStrs []string
-					histStrs = append(histStrs, initialHistory...)
-
-					for _, h := range r.History {
-						// Only include completed messages in context?
-						histStrs = append(histStrs, h.Content)
-					}
-
-					// Notify Frontend: Start of turn
-					r.Broadcast <- Message{Sender: ag.Name, Type: "start"}
-
-					// Stream
-					stream, err := ag.SpeakStream(histStrs)
-					if err != nil {
-						r.Broadcast <- Message{Sender: ag.Name, Content: "[Error: " + err.Error() + "]", Type: "end"}
-						time.Sleep(2 * time.Second)
-						continue
-					}
-
-					var fullContentBuilder strings.Builder
-					var interrupted bool
-
-					// Manual Loop for Select
-				loop:
-					for {
-						// Prioritize Stop signal
-						select {
-						case <-r.Stop:
-							log.Printf("Agent %s loop stopped via priority signal", ag.Name)
-							// Save partial content
-							partialContent := fullContentBuilder.String() + " [Paused]"
-							r.History = append(r.History, Message{
-								Sender:  ag.Name,
-								Content: ag.Name + ": " + partialContent,
-								Type:    "full",
-							})
-							return
-						default:
-						}
-
-						select {
-						case <-r.Stop:
-							log.Printf("Agent %s loop stopped via standard signal", ag.Name)
-							// Save partial content
-							partialContent := fullContentBuilder.String() + " [Paused]"
-							r.History = append(r.History, Message{
-								Sender:  ag.Name,
-								Content: ag.Name + ": " + partialContent,
-								Type:    "full",
-							})
-							return
-						// 1. Interruption Check (Inside the loop!)
-						case userMsg := <-r.InputChan:
-							interrupted = true
-							log.Printf("User interrupted %s: %s", ag.Name, userMsg.Content)
-
-							// Broadcast User Message immediately
-							r.Broadcast <- userMsg
-
-							// We stop consuming stream here.
-							// Ideally we should cancel context, but we don't hold one here.
-							// The stream goroutine in llm/client.go will verify write to 'out' channel.
-							// If we stop reading 'out', it blocks.
-							// So we should launch a drainer in background to avoid leak.
-							go func(c <-chan string) {
-								for range c {
-								} // Drain
-							}(stream)
-
-							// Append pending content to history (Interrupted Agent)
-							interruptedContent := fullContentBuilder.String() + " [Interrupted]"
-							r.History = append(r.History, Message{
-								Sender:  ag.Name,
-								Content: ag.Name + ": " + interruptedContent,
-								Type:    "full",
-							})
-
-							// Append User Message to history
-							r.History = append(r.History, Message{
-								Sender:  userMsg.Sender,
-								Content: "User (Intervention): " + userMsg.Content,
-								Type:    "full",
-							})
-
-							// Notify Frontend: End of Agent turn (even if abrupt)
-							r.Broadcast <- Message{Sender: ag.Name, Type: "end"}
-
-							// Break inner loop -> Next Agent's Turn
-							break loop
-
-							// 2. Stream Consumption
-						case chunk, ok := <-stream:
-							if !ok {
-								break loop // Stream finished naturally
-							}
-							fullContentBuilder.WriteString(chunk)
-							r.Broadcast <- Message{Sender: ag.Name, Content: chunk, Type: "chunk"}
-						}
-					}
-
-					if !interrupted {
-						fullContent := fullContentBuilder.String()
-						log.Printf("Agent %s finished speaking. Length: %d", ag.Name, len(fullContent))
-
-						// Notify Frontend: End of turn
-						r.Broadcast <- Message{Sender: ag.Name, Type: "end"}
-
-						// Save to History (formatted)
-						r.History = append(r.History, Message{
-							Sender:  ag.Name,
-							Content: ag.Name + ": " + fullContent,
-							Type:    "full",
-						})
-
-						// Check Stop after speak
-						select {
-						case <-r.Stop:
-							return
-						default:
-						}
-
-						// Small delay between turns
-						select {
-						case <-time.After(1 * time.Second):
-						case <-r.Stop:
-							return
-						}
-					} else {
-						// If interrupted, maybe smaller delay or immediate next turn?
-						select {
-						case <-time.After(500 * time.Millisecond):
-						case <-r.Stop:
-							return
-						}
-					}
+			// Immediate pause: keep what was said so far and end the turn.
+			case <-r.cutTurn:
+				interrupted = true
+				log.Printf("Agent %s cut off by pause", ag.Name)
+				cancelTurn()
+				r.record(Message{
+					ID:      turnID,
+					Sender:  ag.Name,
+					Content: fullContentBuilder.String() + " [Paused]",
+					Type:    EventMessage,
+				})
+				r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Type: EventTurnEnd})
+				break loop
+			// History edits don't affect the turn in progress, except
+			// regenerate, which throws the in-progress turn away.
+			case cmd := <-r.mailbox:
+				switch cmd.kind {
+				case "inject":
+					// Handled below, as an interruption.
+				case "regenerate":
+					interrupted, discarded = true, true
+					cancelTurn()
+					r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Type: EventTurnEnd})
+					r.emit(ctx, Message{ID: turnID, Sender: "System", Type: EventDelete})
+					r.handle(ctx, cmd)
+					break loop
+				case "judge":
+					// The judge sees what was said so far.
+					log.Printf("Agent %s cut off by the judge", ag.Name)
+					cancelTurn()
+					_, written := notes.Close()
+					ag.Remember(turnID, written...)
+					r.record(Message{
+						ID:      turnID,
+						Sender:  ag.Name,
+						Content: fullContentBuilder.String() + " [Interrupted]",
+						Type:    EventMessage,
+					})
+					r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Type: EventTurnEnd})
+					r.handle(ctx, cmd)
+					return
+				case "snapshot":
+					*cmd.snap = r.snapshot()
+					cmd.snap.Partial = &Message{ID: turnID, Sender: ag.Name, Content: fullContentBuilder.String(), Type: EventTurnStart}
+					cmd.reply <- nil
+					continue
+				default:
+					r.handle(ctx, cmd)
+					continue
+				}
+
+				// 1. Interruption Check (Inside the loop!)
+				userMsg := cmd.msg
+				interrupted = true
+				log.Printf("User interrupted %s: %s", ag.Name, userMsg.Content)
+
+				// Abort the upstream request; the stream goroutine
+				// exits on its own once the context is cancelled.
+				cancelTurn()
+
+				// Broadcast User Message immediately
+				userMsg.ID = NewID()
+				r.emit(ctx, Message{ID: userMsg.ID, Sender: userMsg.Sender, Content: userMsg.Content, Type: EventIntervention})
+
+				// Append pending content to history (Interrupted Agent)
+				interruptedContent := fullContentBuilder.String() + " [Interrupted]"
+				r.record(Message{
+					ID:      turnID,
+					Sender:  ag.Name,
+					Content: interruptedContent,
+					Type:    EventMessage,
+				})
+
+				// Append User Message to history
+				r.record(Message{
+					ID:      userMsg.ID,
+					Sender:  userMsg.Sender,
+					Content: userMsg.Content,
+					Type:    EventMessage,
+				})
+
+				// Notify Frontend: End of Agent turn (even if abrupt)
+				r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Type: EventTurnEnd})
+
+				// Break inner loop -> Next Agent's Turn
+				break loop
+
+				// 2. Stream Consumption
+			case chunk, ok := <-stream.C:
+				if !ok {
+					result = stream.Result()
+					break loop // Stream finished, perhaps cut short (see below)
+				}
+				if chunk = notes.Feed(chunk); chunk == "" {
+					continue
+				}
+				fullContentBuilder.WriteString(chunk)
+				r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Content: chunk, Type: EventChunk})
+				// The phase's length limit ends the turn as if it had finished.
+				if phase != nil && phase.MaxTokens > 0 && ag.LLMClient.Tokenizer().CountTokens(fullContentBuilder.String()) >= phase.MaxTokens {
+					log.Printf("Agent %s reached the %s length limit", ag.Name, phase.Name)
+					break loop
 				}
 			}
 		}
-	}()
+		cancelTurn()
+
+		tail, written := notes.Close()
+		if !discarded {
+			ag.Remember(turnID, written...)
+			if r.Format != nil {
+				r.FormatStep++
+			}
+		}
+		if tail != "" && !interrupted {
+			fullContentBuilder.WriteString(tail)
+			r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Content: tail, Type: EventChunk})
+		}
+
+		// Count the turn (even if interrupted) towards the stop rules.
+		tok := ag.LLMClient.Tokenizer()
+		r.Usage.Turns++
+		if u := result.Usage; u != nil {
+			// The provider's own count, notes included.
+			r.Usage.Tokens += u.PromptTokens + u.CompletionTokens
+		} else {
+			r.Usage.Tokens += llm.CountMessages(tok, ag.Messages(turns)) + tok.CountTokens(fullContentBuilder.String())
+			for _, n := range written {
+				r.Usage.Tokens += tok.CountTokens(n)
+			}
+		}
+		sinceConsensus++
+		sinceSummary++
+
+		if !interrupted {
+			// The stream also closes when ctx is cancelled mid-turn;
+			// keep the partial turn and stop.
+			if ctx.Err() != nil {
+				r.record(Message{
+					ID:      turnID,
+					Sender:  ag.Name,
+					Content: fullContentBuilder.String() + " [Paused]",
+					Type:    EventMessage,
+				})
+				return
+			}
+
+			fullContent := fullContentBuilder.String()
+			log.Printf("Agent %s finished speaking. Length: %d", ag.Name, len(fullContent))
+
+			// Notify Frontend: End of turn
+			r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Type: EventTurnEnd})
+
+			// A stream that errored, stalled or hit the token limit is
+			// kept, but marked so nobody mistakes it for a finished answer.
+			if err := result.Incomplete(); err != nil {
+				log.Printf("Agent %s turn incomplete: %v", ag.Name, err)
+				fullContent += " [Incomplete]"
+				r.emitError(ctx, turnID, ag.Name, err, 0)
+				r.emit(ctx, Message{ID: turnID, Sender: ag.Name, Content: fullContent, Type: EventEdit})
+			}
+
+			// Save to History (formatted)
+			r.record(Message{
+				ID:      turnID,
+				Sender:  ag.Name,
+				Content: fullContent,
+				Type:    EventMessage,
+			})
+
+			// Small delay between turns
+			if !r.sleep(ctx, 1*time.Second) {
+				return
+			}
+		} else {
+			// If interrupted, maybe smaller delay or immediate next turn?
+			if !r.sleep(ctx, 500*time.Millisecond) {
+				return
+			}
+		}
+	}
+}
+
+// judgeClient returns the client used for automatic judging and consensus checks.
+func (r *Room) judgeClient() llm.Provider {
+	if r.JudgeClient != nil {
+		return r.JudgeClient
+	}
+	for _, ag := range r.Agents {
+		if ag.LLMClient != nil {
+			return ag.LLMClient
+		}
+	}
+	return nil
+}
+
+// conclude ends the conversation because a stop rule fired, recording the
+// reason and then judging or plainly stopping according to Rules.OnStop.
+func (r *Room) conclude(ctx context.Context, reason string) {
+	log.Printf("Room stop rule fired: %s", reason)
+	r.StopReason = reason
+	r.emit(ctx, Message{Sender: "System", Content: "Conversation ended: " + reason, Type: EventSystem})
+
+	if r.Rules.OnStop != OnStopStop {
+		if r.judge(ctx) != ErrNoJudge {
+			return
+		}
+	}
+
+	r.StopLoop()
+	r.emit(ctx, Message{Sender: "System", Content: "stop", Type: EventCommand})
 }
 
-// Judge concludes the debate by generating a summary/verdict
-func (r *Room) Judge(client *llm.Client) {
+// judgeOnRequest judges because the user asked to conclude, recording that
+// as the stop reason unless a stop rule already gave one.
+func (r *Room) judgeOnRequest(ctx context.Context) error {
+	err := r.judge(ctx)
+	if err == nil && r.StopReason == "" {
+		r.StopReason = "concluded by user"
+	}
+	return err
+}
+
+// judge concludes the debate with a structured verdict (see JudgeConfig).
+// The verdict is stored on the room, broadcast as a "verdict" message, and
+// recorded in history as Markdown. With a panel, every judge scores in
+// parallel and each ballot is broadcast as it arrives. Cancelling ctx aborts
+// the judge calls.
+func (r *Room) judge(ctx context.Context) error {
+	client := r.judgeClient()
+	if client == nil {
+		return ErrNoJudge
+	}
 	log.Println("Room Judge: Judging conversation...")
 
 	// Ensure loop is stopped
 	r.StopLoop()
 
-	// Give a moment for agents to silence
-	// time.Sleep(500 * time.Millisecond) // No need to sleep if we rely on StopLoop closing channel immediately
-	// But let's verify Stop is respected by Agents.
-
 	// Create a clear break in UI
-	r.Broadcast <- Message{Sender: "System", Content: "Judging...", Type: "system"}
+	r.emit(ctx, Message{Sender: "System", Content: "Judging...", Type: EventSystem})
+
+	names := agentNames(r.Agents)
 
-	// 2. Prepare History
-	var histStrs []string
-	for _, h := range r.History {
-		histStrs = append(histStrs, h.Content)
+	var verdict Verdict
+	var reply string
+	var err error
+	if len(r.JudgeConfig.Panel) > 0 {
+		verdict, err = r.judgePanel(ctx, client, names)
+	} else {
+		verdict, reply, err = r.judgeOnce(ctx, client, r.JudgeConfig, names)
+	}
+	if ctx.Err() != nil {
+		// The client went away; don't record a truncated verdict.
+		return ctx.Err()
 	}
 
-	judgePrompt := "你是一位公正、幽默的辩论裁判。请阅读以上辩论记录，对双方的表现进行点评，指出亮眼之处和逻辑漏洞，并最终判定胜负（或平局）。请用Markdown格式输出，字数控制在500字以内。"
+	content := reply
+	if err != nil {
+		log.Printf("Judge returned no usable verdict: %v", err)
+		r.emitError(ctx, "", JudgeName, err, 0)
+		if content == "" {
+			content = "裁判把自己关在厕所里了..."
+		}
+		// Otherwise keep the free-form reply rather than losing the judgement.
+	} else {
+		r.Verdict = &verdict
+		content = verdict.Markdown()
+		if data, err := json.Marshal(verdict); err == nil {
+			r.emit(ctx, Message{Sender: JudgeName, Content: string(data), Type: EventVerdict})
+		}
+	}
 
-	// 3. Call LLM (Streaming)
-	r.Broadcast <- Message{Sender: "Judge", Type: "start"}
+	// Save Verdict
+	msg := r.record(Message{
+		Sender:  JudgeName,
+		Content: content,
+		Type:    EventMessage,
+	})
+	r.emit(ctx, Message{ID: msg.ID, Sender: JudgeName, Content: content, Type: EventMessage})
 
-	// We pass a dummy history or just the prompt?
-	// ChatStream expects systemPrompt + history.
-	// JudgePrompt is system-like instructions.
+	// Signal Stop to Frontend
+	r.emit(ctx, Message{Sender: "System", Content: "stop", Type: EventCommand})
+	return nil
+}
 
-	// Let's use Judge Prompt as system, and history as history.
-	stream, err := client.ChatStream(judgePrompt, histStrs)
+// judgeOnce asks one judge for its verdict. The raw reply is returned too,
+// so it can be kept if it doesn't parse.
+func (r *Room) judgeOnce(ctx context.Context, client llm.Provider, cfg JudgeConfig, names []string) (Verdict, string, error) {
+	// The running summary stands in for older turns.
+	prompt := cfg.systemPrompt(names)
+	reply, err := client.Chat(ctx, client.Messages(prompt, "", r.promptTurns(), r.NameStyle))
 	if err != nil {
-		r.Broadcast <- Message{Sender: "Judge", Content: "裁判把自己关在厕所里了...", Type: "end"}
-		return
+		return Verdict{}, "", err
 	}
+	v, err := cfg.parseVerdict(reply, names)
+	return v, reply, err
+}
 
-	var fullContentBuilder strings.Builder
-	for chunk := range stream {
-		fullContentBuilder.WriteString(chunk)
-		r.Broadcast <- Message{Sender: "Judge", Content: chunk, Type: "chunk"}
+// judgePanel runs every panel judge in parallel, broadcasting each ballot
+// as it comes in, and aggregates them.
+func (r *Room) judgePanel(ctx context.Context, client llm.Provider, names []string) (Verdict, error) {
+	panel := r.JudgeConfig.Panel
+	results := make(chan Ballot, len(panel))
+	for i := range panel {
+		cfg := r.JudgeConfig.Panelist(i)
+		c := client
+		if i < len(r.PanelClients) && r.PanelClients[i] != nil {
+			c = r.PanelClients[i]
+		}
+		go func() {
+			b := Ballot{Judge: cfg.Name}
+			v, _, err := r.judgeOnce(ctx, c, cfg, names)
+			if err != nil {
+				b.Error = err.Error()
+			} else {
+				b.Verdict = &v
+			}
+			results <- b
+		}()
 	}
 
-	fullContent := fullContentBuilder.String()
-	r.Broadcast <- Message{Sender: "Judge", Type: "end"}
-
-	// 4. Save Verdict
-	r.History = append(r.History, Message{
-		Sender:  "Judge",
-		Content: "Judge: " + fullContent,
-		Type:    "full",
-	})
+	ballots := make([]Ballot, 0, len(panel))
+	for range panel {
+		var b Ballot
+		select {
+		case b = <-results:
+		case <-ctx.Done():
+			return Verdict{}, ctx.Err()
+		}
+		ballots = append(ballots, b)
+		if data, err := json.Marshal(b); err == nil {
+			r.emit(ctx, Message{Sender: b.Judge, Content: string(data), Type: EventBallot})
+		}
+	}
+	return aggregate(r.JudgeConfig.Aggregate, ballots, names)
+}
 
-	// 5. Signal Stop to Frontend
-	r.Broadcast <- Message{Sender: "System", Content: "stop", Type: "cmd"}
+// Done is closed once the owner goroutine started by StartLoop has exited,
+// after the ctx given to StartLoop ended. From then on the room's state is
+// final and may be read directly.
+func (r *Room) Done() <-chan struct{} {
+	return r.done
 }
 
-// StopLoop stops the conversation.
+// StopLoop stops the conversation. The owner keeps serving commands until
+// the ctx given to StartLoop ends. Stopping twice is harmless.
 func (r *Room) StopLoop() {
-	// Check if channel is already closed to avoid panic?
-	// Or use sync.Once. For MVP, simple close.
-	select {
-	case <-r.Stop:
-		// already closed
-	default:
+	r.stopOnce.Do(func() {
 		log.Println("Stopping Room Loop...")
 		close(r.Stop)
-	}
+	})
 }
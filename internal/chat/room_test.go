@@ -1,7 +1,16 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"qigent/internal/agent"
+	"qigent/internal/llm"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -11,15 +20,16 @@ func TestRoomLoop(t *testing.T) {
 	a2 := agent.NewAgent("A2", "Prompt 2", nil)
 
 	room := NewRoom([]*agent.Agent{a1, a2})
+	events := room.Events.Subscribe(16, OverflowDisconnect)
 
 	// Start the loop in a goroutine
-	room.StartLoop("")
+	room.StartLoop(context.Background(), "")
 
 	// Helper function to read a message with timeout
 	readMessage := func(timeout time.Duration) *Message {
 		select {
-		case msg := <-room.Broadcast:
-			return &msg
+		case ev := <-events.C:
+			return &ev.Message
 		case <-time.After(timeout):
 			return nil
 		}
@@ -42,3 +52,262 @@ func TestRoomLoop(t *testing.T) {
 
 	room.StopLoop()
 }
+
+func TestRoomPauseResume(t *testing.T) {
+	room := NewRoom([]*agent.Agent{agent.NewAgent("A1", "", nil), agent.NewAgent("A2", "", nil)})
+	room.Pause(false)
+	events := room.Events.Subscribe(16, OverflowDisconnect)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	room.StartLoop(ctx, "")
+
+	next := func() Message {
+		select {
+		case ev := <-events.C:
+			return ev.Message
+		case <-time.After(4 * time.Second):
+			t.Fatal("timeout waiting for message")
+		}
+		return Message{}
+	}
+
+	if msg := next(); msg.Type != "status" || msg.Content != "paused" {
+		t.Fatalf("expected paused status, got %+v", msg)
+	}
+	room.Resume()
+	if msg := next(); msg.Type != "status" || msg.Content != "active" {
+		t.Fatalf("expected active status, got %+v", msg)
+	}
+	if msg := next(); msg.Type != "start" || msg.Sender != "A1" {
+		t.Fatalf("expected A1 to start after resume, got %+v", msg)
+	}
+	room.StopLoop()
+}
+
+// Run with -race: commands from many goroutines must all be served by the
+// room's owner without touching its state concurrently.
+func TestRoomCommandsFromManyGoroutines(t *testing.T) {
+	room := NewRoom(testAgents("A", "B"))
+	room.History = []Message{{ID: "m1", Sender: "User", Content: "hi", Type: EventMessage}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	room.StartLoop(ctx, "")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if _, err := room.Snapshot(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			room.InjectMessage(Message{Sender: "User", Content: "interrupt"})
+		}()
+		go func() {
+			defer wg.Done()
+			if err := room.EditMessage(ctx, "m1", "edited"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := room.Judge(ctx); err != ErrNoJudge {
+			t.Errorf("Judge without a judge model: err = %v, want ErrNoJudge", err)
+		}
+	}()
+	wg.Wait()
+
+	cancel()
+	<-room.Done()
+	snap, err := room.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.History[0].Content != "edited" {
+		t.Fatalf("first message = %q, want the edit", snap.History[0].Content)
+	}
+	injected := 0
+	for _, m := range snap.History {
+		if m.Content == "interrupt" {
+			injected++
+		}
+	}
+	if injected != 5 {
+		t.Fatalf("recorded %d interventions, want 5", injected)
+	}
+}
+
+// A rate-limited turn is retried in place; the room only moves on once it
+// has an answer.
+func TestRoomRetriesRateLimitedTurn(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			http.Error(w, `{"error":"slow down"}`, http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"hello\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	room := NewRoom([]*agent.Agent{agent.NewAgent("A", "", llm.NewClient(llm.Config{BaseURL: srv.URL}))})
+	room.Retry = &llm.RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond}
+	events := room.Events.Subscribe(64, OverflowDisconnect)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	room.StartLoop(ctx, "")
+
+	var retried bool
+	for {
+		select {
+		case ev := <-events.C:
+			switch ev.Type {
+			case EventError:
+				t.Fatalf("error event before retries ran out: %s", ev.Content)
+			case EventSystem:
+				retried = retried || strings.Contains(ev.Content, string(llm.KindRateLimit))
+			case EventChunk:
+				if !retried || ev.Content != "hello" {
+					t.Fatalf("chunk %q, retried = %v", ev.Content, retried)
+				}
+				return
+			}
+		case <-time.After(4 * time.Second):
+			t.Fatal("timeout waiting for the retried turn")
+		}
+	}
+}
+
+// Once retries are exhausted the room reports a typed error.
+func TestRoomReportsTypedErrorAfterRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	room := NewRoom([]*agent.Agent{agent.NewAgent("A", "", llm.NewClient(llm.Config{BaseURL: srv.URL}))})
+	room.Retry = &llm.RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond}
+	events := room.Events.Subscribe(64, OverflowDisconnect)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	room.StartLoop(ctx, "")
+
+	for {
+		select {
+		case ev := <-events.C:
+			if ev.Type != EventError {
+				continue
+			}
+			var e ErrorEvent
+			if err := json.Unmarshal([]byte(ev.Content), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Kind != llm.KindServer || e.Attempts != 2 {
+				t.Fatalf("error event = %+v, want a server error after 2 attempts", e)
+			}
+			return
+		case <-time.After(4 * time.Second):
+			t.Fatal("timeout waiting for the error event")
+		}
+	}
+}
+
+// A stream that breaks off mid-answer is kept but marked incomplete.
+func TestRoomMarksIncompleteTurn(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"half an\"}}]}\n\n")
+	}))
+	defer srv.Close()
+
+	room := NewRoom([]*agent.Agent{agent.NewAgent("A", "", llm.NewClient(llm.Config{BaseURL: srv.URL}))})
+	events := room.Events.Subscribe(64, OverflowDisconnect)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	room.StartLoop(ctx, "")
+
+	for {
+		select {
+		case ev := <-events.C:
+			if ev.Type != EventEdit {
+				continue
+			}
+			snap, err := room.Snapshot(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			last := snap.History[len(snap.History)-1]
+			if last.ID != ev.ID || last.Content != "half an [Incomplete]" {
+				t.Fatalf("last entry = %+v, want the marked partial turn", last)
+			}
+			return
+		case <-time.After(4 * time.Second):
+			t.Fatal("timeout waiting for the incomplete turn")
+		}
+	}
+}
+
+// An immediate pause with no turn streaming must not cut off the first
+// turn after a quick resume.
+func TestRoomResumeDropsStaleCut(t *testing.T) {
+	room := NewRoom(testAgents("A"))
+	room.Pause(true)
+	room.Resume()
+	select {
+	case <-room.cutTurn:
+		t.Fatal("cut signal survived Resume")
+	default:
+	}
+}
+
+// A room stopped before it starts never takes a turn, and concluding it
+// records why it ended.
+func TestRoomStoppedBeforeStartOnlyJudges(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"A wins\"}}]}\n\ndata: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	room := NewRoom([]*agent.Agent{agent.NewAgent("A", "", llm.NewClient(llm.Config{BaseURL: srv.URL}))})
+	room.History = []Message{{ID: "1", Sender: "A", Content: "done"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	room.StopLoop()
+	room.StartLoop(ctx, "")
+
+	if err := room.Judge(ctx); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := room.Snapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snap.StopReason != "concluded by user" || calls.Load() != 1 {
+		t.Errorf("stop reason = %q after %d calls, want the judge's call only", snap.StopReason, calls.Load())
+	}
+}
+
+// Once the conversation has ended nothing would take a regenerated turn,
+// so the room keeps it.
+func TestRoomFinishedRefusesRegenerate(t *testing.T) {
+	room := NewRoom(testAgents("A"))
+	room.History = []Message{{ID: "1", Sender: "A", Content: "last word"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	room.StopLoop()
+	room.StartLoop(ctx, "")
+
+	if err := room.Regenerate(ctx); err != ErrRoomStopped {
+		t.Fatalf("Regenerate err = %v, want ErrRoomStopped", err)
+	}
+	if snap, _ := room.Snapshot(ctx); len(snap.History) != 1 {
+		t.Errorf("history = %+v, want the turn kept", snap.History)
+	}
+}
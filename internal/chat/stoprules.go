@@ -0,0 +1,96 @@
+package chat
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"qigent/internal/llm"
+	"strings"
+	"time"
+)
+
+// What the room does once a stop rule fires.
+const (
+	OnStopJudge = "judge" // Run the judge, then stop (default)
+	OnStopStop  = "stop"  // Just stop
+)
+
+// StopRules are per-conversation termination conditions. Zero values disable a rule.
+type StopRules struct {
+	MaxTurns       int     `json:"maxTurns,omitempty"`       // Agent turns, across resumes
+	MaxTokens      int     `json:"maxTokens,omitempty"`      // Estimated prompt+completion tokens
+	MaxCost        float64 `json:"maxCost,omitempty"`        // In the same currency as CostPer1K
+	CostPer1K      float64 `json:"costPer1k,omitempty"`      // Price per 1000 tokens, used by MaxCost
+	MaxDurationSec int     `json:"maxDurationSec,omitempty"` // Running time, checked between turns
+	Consensus      bool    `json:"consensus,omitempty"`      // Ask an LLM classifier after every full round
+	OnStop         string  `json:"onStop,omitempty"`         // OnStopJudge or OnStopStop
+}
+
+// Validate rejects negative limits and unknown OnStop values.
+func (s StopRules) Validate() error {
+	if s.MaxTurns < 0 || s.MaxTokens < 0 || s.MaxCost < 0 || s.CostPer1K < 0 || s.MaxDurationSec < 0 {
+		return fmt.Errorf("stop rule limits must not be negative")
+	}
+	if s.MaxCost > 0 && s.CostPer1K == 0 {
+		return fmt.Errorf("maxCost requires costPer1k")
+	}
+	switch s.OnStop {
+	case "", OnStopJudge, OnStopStop:
+		return nil
+	default:
+		return fmt.Errorf("unknown onStop action: %s", s.OnStop)
+	}
+}
+
+// Usage is what the stop rules are measured against. It is persisted with
+// the conversation so limits hold across reconnects.
+type Usage struct {
+	Turns      int     `json:"turns"`
+	Tokens     int     `json:"tokens"`
+	ElapsedSec float64 `json:"elapsedSec"`
+}
+
+// Cost returns the estimated spend for the given price per 1000 tokens.
+func (u Usage) Cost(per1K float64) float64 {
+	return float64(u.Tokens) / 1000 * per1K
+}
+
+// exceeded returns the name of the first count-based rule that has fired, or "".
+func (s StopRules) exceeded(u Usage) string {
+	switch {
+	case s.MaxTurns > 0 && u.Turns >= s.MaxTurns:
+		return fmt.Sprintf("max_turns (%d)", s.MaxTurns)
+	case s.MaxTokens > 0 && u.Tokens >= s.MaxTokens:
+		return fmt.Sprintf("max_tokens (%d)", s.MaxTokens)
+	case s.MaxCost > 0 && u.Cost(s.CostPer1K) >= s.MaxCost:
+		return fmt.Sprintf("max_cost (%.2f)", s.MaxCost)
+	case s.MaxDurationSec > 0 && u.ElapsedSec >= float64(s.MaxDurationSec):
+		return fmt.Sprintf("max_duration (%s)", time.Duration(s.MaxDurationSec)*time.Second)
+	}
+	return ""
+}
+
+const consensusPrompt = `You are a neutral classifier. Read the discussion above and decide whether the participants have reached consensus, or are only repeating themselves. Reply with JSON only: {"consensus": true|false, "reason": "<one sentence>"}`
+
+// checkConsensus asks the classifier whether the discussion has converged.
+func checkConsensus(ctx context.Context, client llm.Provider, turns []llm.Turn, style llm.NameStyle) (bool, string, error) {
+	messages := client.Messages(consensusPrompt, "", turns, style)
+	reply, err := client.Chat(ctx, messages)
+	if err != nil {
+		return false, "", err
+	}
+
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return false, "", fmt.Errorf("consensus reply has no JSON object: %q", reply)
+	}
+	var verdict struct {
+		Consensus bool   `json:"consensus"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &verdict); err != nil {
+		return false, "", fmt.Errorf("invalid consensus JSON: %w", err)
+	}
+	return verdict.Consensus, verdict.Reason, nil
+}
@@ -0,0 +1,31 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStopRulesExceeded(t *testing.T) {
+	rules := StopRules{MaxTurns: 4, MaxCost: 0.5, CostPer1K: 1}
+	if got := rules.exceeded(Usage{Turns: 3, Tokens: 100}); got != "" {
+		t.Fatalf("exceeded = %q, want none", got)
+	}
+	if got := rules.exceeded(Usage{Turns: 4}); !strings.HasPrefix(got, "max_turns") {
+		t.Fatalf("exceeded = %q, want max_turns", got)
+	}
+	if got := rules.exceeded(Usage{Turns: 1, Tokens: 600}); !strings.HasPrefix(got, "max_cost") {
+		t.Fatalf("exceeded = %q, want max_cost", got)
+	}
+}
+
+func TestStopRulesValidate(t *testing.T) {
+	if err := (StopRules{MaxCost: 1}).Validate(); err == nil {
+		t.Fatal("expected error for maxCost without price")
+	}
+	if err := (StopRules{OnStop: "explode"}).Validate(); err == nil {
+		t.Fatal("expected error for unknown onStop")
+	}
+	if err := (StopRules{MaxTurns: 10, OnStop: OnStopStop}).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,94 @@
+package chat
+
+import (
+	"context"
+	"qigent/internal/llm"
+	"strings"
+)
+
+// summaryKeepRecent is how many of the newest history messages are never
+// folded into the summary, so agents always see the latest exchange verbatim.
+const summaryKeepRecent = 6
+
+const summaryPrompt = `You maintain the running memory of a long discussion. Update the summary with the new messages: keep each participant's positions, key arguments, concessions and open questions, and drop small talk. Write plain prose of at most 300 words, in the language of the discussion. Reply with the summary only.`
+
+// Summary compresses the older part of a conversation. It covers every
+// history message up to and including the one with ID Through.
+type Summary struct {
+	Text    string `json:"text,omitempty"`
+	Through string `json:"through,omitempty"`
+}
+
+// covered returns how many leading history messages the summary replaces,
+// or 0 if it covers nothing (or its boundary message was deleted).
+func (s Summary) covered(history []Message) int {
+	if s.Text == "" || s.Through == "" {
+		return 0
+	}
+	return indexOfID(history, s.Through) + 1
+}
+
+// Covers reports whether the summary only describes messages in history.
+func (s Summary) Covers(history []Message) bool {
+	return s.covered(history) > 0
+}
+
+// withSummary is transcript with the summarized stretch replaced by the
+// summary itself, after the topic (if any). The topic and the summary are
+// both pinned.
+func withSummary(history []Message, s Summary, topic string) []llm.Turn {
+	turns := make([]llm.Turn, 0, len(history)+2)
+	if topic != "" {
+		turns = append(turns, topicTurn(topic))
+	}
+	n := s.covered(history)
+	if n == 0 {
+		return append(turns, transcript(history[seedLen(history, topic):])...)
+	}
+
+	turns = append(turns, llm.Turn{Content: "Summary of the earlier discussion:\n" + s.Text, Pinned: true})
+	for _, m := range history[n:] {
+		turns = append(turns, llm.Turn{Speaker: m.Sender, Content: m.Content})
+	}
+	return turns
+}
+
+// promptTurns returns the transcript the room shows to models: the running
+// summary in place of older turns, if there is one.
+func (r *Room) promptTurns() []llm.Turn {
+	return withSummary(r.History, r.Summary, r.Topic)
+}
+
+// updateSummary folds the messages between the current summary and the
+// recent tail into a new summary. It reports whether the summary changed.
+func (r *Room) updateSummary(ctx context.Context, client llm.Provider) (bool, error) {
+	from := r.Summary.covered(r.History)
+	if from == 0 {
+		from = seedLen(r.History, r.Topic) // The topic is always kept as-is
+	}
+	to := len(r.History) - summaryKeepRecent
+	if to <= from {
+		return false, nil
+	}
+
+	var turns []llm.Turn
+	if r.Summary.covered(r.History) > 0 {
+		turns = append(turns, llm.Turn{Content: "Current summary:\n" + r.Summary.Text, Pinned: true})
+	}
+	turns = append(turns, transcript(r.History[from:to])...)
+	// The new stretch must not be trimmed away: what doesn't fit is lost for good.
+	for i := range turns {
+		turns[i].Pinned = true
+	}
+
+	text, err := client.Chat(ctx, client.Messages(summaryPrompt, "", turns, r.NameStyle))
+	if err != nil {
+		return false, err
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return false, nil
+	}
+	r.Summary = Summary{Text: text, Through: r.History[to-1].ID}
+	return true, nil
+}
@@ -0,0 +1,47 @@
+package chat
+
+import "testing"
+
+func TestWithSummary(t *testing.T) {
+	history := []Message{
+		{ID: "0", Sender: ModeratorName, Content: seedText("T")},
+		{ID: "1", Sender: "A", Content: "one"},
+		{ID: "2", Sender: "B", Content: "two"},
+		{ID: "3", Sender: "A", Content: "three"},
+	}
+
+	turns := withSummary(history, Summary{Text: "A and B disagree.", Through: "2"}, "T")
+	if len(turns) != 3 {
+		t.Fatalf("got %d turns, want seed + summary + 1: %+v", len(turns), turns)
+	}
+	if turns[0].Content != seedText("T") || !turns[0].Pinned {
+		t.Fatalf("seed = %+v, want pinned topic", turns[0])
+	}
+	if !turns[1].Pinned || turns[1].Speaker != "" {
+		t.Fatalf("summary turn = %+v, want pinned instruction", turns[1])
+	}
+	if turns[2].Content != "three" {
+		t.Fatalf("last turn = %+v, want three", turns[2])
+	}
+
+	// A summary whose boundary is gone is ignored.
+	if got := withSummary(history, Summary{Text: "stale", Through: "missing"}, "T"); len(got) != len(history) {
+		t.Fatalf("got %d turns, want the full transcript", len(got))
+	}
+}
+
+// The topic is pinned even when the history doesn't open with its seed,
+// e.g. a fork that starts with an intervention.
+func TestTopicPinnedWithoutSeed(t *testing.T) {
+	history := []Message{
+		{ID: "1", Sender: "User", Content: "interjection"},
+		{ID: "2", Sender: "A", Content: "one"},
+	}
+	turns := withSummary(history, Summary{}, "T")
+	if len(turns) != 3 || turns[0].Content != seedText("T") || !turns[0].Pinned {
+		t.Fatalf("turns = %+v, want the pinned topic first", turns)
+	}
+	if turns[1].Pinned {
+		t.Fatalf("first history turn %+v is pinned; only the topic should be", turns[1])
+	}
+}
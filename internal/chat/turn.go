@@ -0,0 +1,183 @@
+package chat
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"qigent/internal/agent"
+	"strings"
+)
+
+// Turn policy names, as stored on a conversation.
+const (
+	PolicyRoundRobin     = "round_robin"
+	PolicyWeightedRandom = "weighted_random"
+	PolicyLeastRecent    = "least_recent"
+	PolicyAddressed      = "addressed"
+)
+
+// TurnPolicy decides which agent speaks next.
+//
+// last is the index of the previous speaker (-1 if nobody has spoken yet).
+// When more than one agent is present, a policy must not return last again,
+// so a failing agent can't stall the room.
+type TurnPolicy interface {
+	Next(agents []*agent.Agent, history []Message, last int) int
+}
+
+// NewTurnPolicy builds a policy by name. An empty name means round-robin.
+// weights are only used by weighted random and are aligned with the agents slice.
+func NewTurnPolicy(name string, weights []float64) (TurnPolicy, error) {
+	switch name {
+	case "", PolicyRoundRobin:
+		return RoundRobin{}, nil
+	case PolicyWeightedRandom:
+		return WeightedRandom{Weights: weights}, nil
+	case PolicyLeastRecent:
+		return LeastRecent{}, nil
+	case PolicyAddressed:
+		return Addressed{Fallback: RoundRobin{}}, nil
+	default:
+		return nil, fmt.Errorf("unknown turn policy: %s", name)
+	}
+}
+
+// LastSpeaker is the turn recovery logic: it searches history backwards
+// (skipping "System", "User", "Judge", ...) for the most recent agent turn
+// and returns that agent's index, or -1 if no agent has spoken yet.
+func LastSpeaker(agents []*agent.Agent, history []Message) int {
+	for j := len(history) - 1; j >= 0; j-- {
+		if k := agentIndex(agents, history[j].Sender); k >= 0 {
+			return k
+		}
+	}
+	return -1
+}
+
+func agentNames(agents []*agent.Agent) []string {
+	names := make([]string, len(agents))
+	for i, ag := range agents {
+		names[i] = ag.Name
+	}
+	return names
+}
+
+func agentIndex(agents []*agent.Agent, name string) int {
+	for k, ag := range agents {
+		if ag.Name == name {
+			return k
+		}
+	}
+	return -1
+}
+
+// RoundRobin cycles through agents in order.
+type RoundRobin struct{}
+
+func (RoundRobin) Next(agents []*agent.Agent, history []Message, last int) int {
+	return (last + 1) % len(agents)
+}
+
+// WeightedRandom picks a random agent other than the last speaker, with
+// probability proportional to its weight. Missing or non-positive weights count as 1.
+type WeightedRandom struct {
+	Weights []float64
+}
+
+func (p WeightedRandom) Next(agents []*agent.Agent, history []Message, last int) int {
+	if len(agents) == 1 {
+		return 0
+	}
+
+	weight := func(i int) float64 {
+		if i == last {
+			return 0
+		}
+		if i < len(p.Weights) && p.Weights[i] > 0 {
+			return p.Weights[i]
+		}
+		return 1
+	}
+
+	var total float64
+	for i := range agents {
+		total += weight(i)
+	}
+
+	target := rand.Float64() * total
+	for i := range agents {
+		target -= weight(i)
+		if target < 0 {
+			return i
+		}
+	}
+	// Float rounding: fall back to the last eligible agent.
+	for i := len(agents) - 1; i >= 0; i-- {
+		if i != last {
+			return i
+		}
+	}
+	return 0
+}
+
+// LeastRecent picks the agent whose last turn is furthest back in history.
+// Agents that have never spoken go first; ties break by agent order.
+type LeastRecent struct{}
+
+func (LeastRecent) Next(agents []*agent.Agent, history []Message, last int) int {
+	if len(agents) == 1 {
+		return 0
+	}
+
+	lastSeen := make([]int, len(agents))
+	for i := range lastSeen {
+		lastSeen[i] = -1
+	}
+	for j, msg := range history {
+		if k := agentIndex(agents, msg.Sender); k >= 0 {
+			lastSeen[k] = j
+		}
+	}
+
+	best := -1
+	for i := range agents {
+		if i == last {
+			continue
+		}
+		if best == -1 || lastSeen[i] < lastSeen[best] {
+			best = i
+		}
+	}
+	return best
+}
+
+// Addressed hands the turn to whichever agent the latest message mentions
+// by name (the earliest mention wins). If nobody is addressed it defers to Fallback.
+type Addressed struct {
+	Fallback TurnPolicy
+}
+
+func (p Addressed) Next(agents []*agent.Agent, history []Message, last int) int {
+	if len(history) > 0 {
+		msg := history[len(history)-1]
+		text := msg.Content
+
+		best, bestPos := -1, -1
+		for i, ag := range agents {
+			if i == last || ag.Name == msg.Sender {
+				continue
+			}
+			pos := strings.Index(text, ag.Name)
+			if pos >= 0 && (bestPos == -1 || pos < bestPos) {
+				best, bestPos = i, pos
+			}
+		}
+		if best >= 0 {
+			return best
+		}
+	}
+
+	if p.Fallback == nil {
+		return RoundRobin{}.Next(agents, history, last)
+	}
+	return p.Fallback.Next(agents, history, last)
+}
@@ -0,0 +1,79 @@
+package chat
+
+import (
+	"qigent/internal/agent"
+	"testing"
+)
+
+func testAgents(names ...string) []*agent.Agent {
+	var agents []*agent.Agent
+	for _, n := range names {
+		agents = append(agents, agent.NewAgent(n, "", nil))
+	}
+	return agents
+}
+
+func TestLastSpeakerRecovery(t *testing.T) {
+	agents := testAgents("A", "B", "C")
+	history := []Message{
+		{Sender: "A", Content: "hi"},
+		{Sender: "B", Content: "hello"},
+		{Sender: "User", Content: "User (Intervention): go on"},
+	}
+	if got := LastSpeaker(agents, history); got != 1 {
+		t.Fatalf("LastSpeaker = %d, want 1", got)
+	}
+	if got := (RoundRobin{}).Next(agents, history, LastSpeaker(agents, history)); got != 2 {
+		t.Fatalf("RoundRobin after recovery = %d, want 2", got)
+	}
+	if got := LastSpeaker(agents, nil); got != -1 {
+		t.Fatalf("LastSpeaker(empty) = %d, want -1", got)
+	}
+}
+
+func TestLeastRecent(t *testing.T) {
+	agents := testAgents("A", "B", "C")
+	history := []Message{
+		{Sender: "C", Content: "one"},
+		{Sender: "A", Content: "two"},
+	}
+	// B never spoke, so it goes first.
+	if got := (LeastRecent{}).Next(agents, history, 0); got != 1 {
+		t.Fatalf("LeastRecent = %d, want 1", got)
+	}
+	history = append(history, Message{Sender: "B", Content: "three"})
+	if got := (LeastRecent{}).Next(agents, history, 1); got != 2 {
+		t.Fatalf("LeastRecent = %d, want 2", got)
+	}
+}
+
+func TestAddressed(t *testing.T) {
+	agents := testAgents("Alice", "Bob", "Carol")
+	p := Addressed{Fallback: RoundRobin{}}
+
+	history := []Message{{Sender: "Alice", Content: "Carol, what do you think? Bob too."}}
+	if got := p.Next(agents, history, 0); got != 2 {
+		t.Fatalf("Addressed = %d, want 2 (Carol)", got)
+	}
+
+	history = []Message{{Sender: "Alice", Content: "nobody in particular"}}
+	if got := p.Next(agents, history, 0); got != 1 {
+		t.Fatalf("Addressed fallback = %d, want 1", got)
+	}
+}
+
+func TestWeightedRandomNeverRepeats(t *testing.T) {
+	agents := testAgents("A", "B", "C")
+	p := WeightedRandom{Weights: []float64{100, 1, 1}}
+	for i := 0; i < 200; i++ {
+		if got := p.Next(agents, nil, 0); got == 0 {
+			t.Fatal("WeightedRandom picked the last speaker again")
+		}
+	}
+}
+
+func TestNewTurnPolicyUnknown(t *testing.T) {
+	if _, err := NewTurnPolicy("chaos", nil); err == nil {
+		t.Fatal("expected error for unknown policy")
+	}
+}
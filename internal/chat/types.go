@@ -2,9 +2,12 @@ package chat
 
 // Message represents a single turn or a chunk in the conversation.
 type Message struct {
-	Sender  string `json:"sender"`
-	Content string `json:"content"`
-	Type    string `json:"type"` // "start", "chunk", "end", "system"
+	// ID is stable for the lifetime of a history entry. Streaming events of
+	// one turn (start/chunk/end) share the ID of the entry they produce.
+	ID      string    `json:"id,omitempty"`
+	Sender  string    `json:"sender"`
+	Content string    `json:"content"`
+	Type    EventType `json:"type"` // One of the Event* types; "full" for history entries
 }
 
 // Conversation holds the history of messages.
@@ -18,11 +18,21 @@ func InitDB(dsn string) error {
 	}
 
 	// Migrate the schema
-	err = DB.AutoMigrate(&User{}, &Conversation{}, &Role{}, &ChatConfig{})
+	err = DB.AutoMigrate(&User{}, &Conversation{}, &Role{}, &ChatConfig{}, &Profile{})
 	if err != nil {
 		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
+	if err := migrateAgentList(); err != nil {
+		return fmt.Errorf("failed to migrate conversation agents: %w", err)
+	}
+	if err := migrateRawContent(); err != nil {
+		return fmt.Errorf("failed to migrate history content: %w", err)
+	}
+	if err := migrateMessageIDs(); err != nil {
+		return fmt.Errorf("failed to migrate message IDs: %w", err)
+	}
+
 	log.Println("Database initialized and migrated")
 	return nil
 }
@@ -0,0 +1,90 @@
+package data
+
+import (
+	"fmt"
+	"qigent/internal/agent"
+	"qigent/internal/chat"
+	"time"
+)
+
+// Fork creates a new conversation with c's settings and the first keep
+// messages of its history. The fork records c as its parent and keep as its
+// branch point; usage and stop state start fresh.
+func (c *Conversation) Fork(id string, keep int) (*Conversation, error) {
+	if keep < 0 || keep > len(c.History) {
+		return nil, fmt.Errorf("branch point %d out of range (history has %d messages)", keep, len(c.History))
+	}
+
+	history := make([]chat.Message, keep)
+	copy(history, c.History[:keep])
+	agents := make([]AgentConfig, len(c.Agents))
+	copy(agents, c.Agents)
+
+	var moderator *AgentConfig
+	if c.Moderator != nil {
+		m := *c.Moderator
+		moderator = &m
+	}
+
+	// The summary stays valid only if everything it covers was kept.
+	var summary chat.Summary
+	if c.Summary.Covers(history) {
+		summary = c.Summary
+	}
+
+	// Notes written after the branch point belong to the parent's future.
+	notes := make(map[string][]agent.Note)
+	for name, list := range c.Notes {
+		for _, n := range list {
+			if n.TurnID == "" || containsID(history, n.TurnID) {
+				notes[name] = append(notes[name], n)
+			}
+		}
+	}
+
+	// A format resumes after the agent turns that were kept.
+	step := 0
+	if c.Format != nil {
+		for _, m := range history {
+			for _, a := range agents {
+				if m.Sender == a.Name {
+					step++
+				}
+			}
+		}
+	}
+
+	return &Conversation{
+		ID:           id,
+		UserID:       c.UserID,
+		Topic:        c.Topic,
+		Status:       "active",
+		Agents:       agents,
+		History:      history,
+		TurnPolicy:   c.TurnPolicy,
+		Moderator:    moderator,
+		StopRules:    c.StopRules,
+		OnLastLeave:  c.OnLastLeave,
+		Model:        c.Model,
+		NameStyle:    c.NameStyle,
+		Summary:      summary,
+		SummaryEvery: c.SummaryEvery,
+		Scratchpad:   c.Scratchpad,
+		Judge:        c.Judge,
+		Format:       c.Format,
+		FormatStep:   step,
+		Notes:        notes,
+		ParentID:     c.ID,
+		BranchPoint:  keep,
+		CreatedAt:    time.Now(),
+	}, nil
+}
+
+func containsID(history []chat.Message, id string) bool {
+	for _, m := range history {
+		if m.ID == id {
+			return true
+		}
+	}
+	return false
+}
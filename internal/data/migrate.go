@@ -0,0 +1,119 @@
+package data
+
+import (
+	"encoding/json"
+	"log"
+	"qigent/internal/chat"
+	"strings"
+)
+
+// legacyConversation maps the pre multi-agent columns of the conversations table.
+type legacyConversation struct {
+	ID     string        `gorm:"primaryKey;size:191"`
+	AgentA AgentConfig   `gorm:"serializer:json"`
+	AgentB AgentConfig   `gorm:"serializer:json"`
+	Agents []AgentConfig `gorm:"serializer:json"`
+}
+
+func (legacyConversation) TableName() string { return "conversations" }
+
+// migrateAgentList moves conversations created with fixed AgentA/AgentB
+// columns into the ordered Agents list, then drops the old columns.
+// It is a no-op once the legacy columns are gone.
+func migrateAgentList() error {
+	m := DB.Migrator()
+	if !m.HasColumn(&legacyConversation{}, "agent_a") {
+		return nil
+	}
+
+	var rows []legacyConversation
+	if err := DB.Find(&rows).Error; err != nil {
+		return err
+	}
+
+	migrated := 0
+	for _, row := range rows {
+		if len(row.Agents) > 0 {
+			continue
+		}
+		var agents []AgentConfig
+		for _, a := range []AgentConfig{row.AgentA, row.AgentB} {
+			if a.Name != "" {
+				agents = append(agents, a)
+			}
+		}
+		blob, err := json.Marshal(agents)
+		if err != nil {
+			return err
+		}
+		if err := DB.Model(&legacyConversation{}).Where("id = ?", row.ID).Update("agents", string(blob)).Error; err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	for _, col := range []string{"agent_a", "agent_b"} {
+		if m.HasColumn(&legacyConversation{}, col) {
+			if err := m.DropColumn(&legacyConversation{}, col); err != nil {
+				return err
+			}
+		}
+	}
+
+	log.Printf("Migrated %d conversations to agent lists", migrated)
+	return nil
+}
+
+// migrateMessageIDs gives every stored history message a stable ID, so
+// messages saved before IDs existed can be edited, deleted or forked from.
+func migrateMessageIDs() error {
+	var convs []Conversation
+	if err := DB.Select("id", "history").Find(&convs).Error; err != nil {
+		return err
+	}
+
+	migrated := 0
+	for _, conv := range convs {
+		if !chat.EnsureIDs(conv.History) {
+			continue
+		}
+		if err := DB.Model(&conv).Select("history").Updates(&conv).Error; err != nil {
+			return err
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		log.Printf("Assigned message IDs in %d conversations", migrated)
+	}
+	return nil
+}
+
+// migrateRawContent strips the "Sender: " prefixes that legacy rows stored in
+// every history message, so speaker names are applied only when prompting.
+func migrateRawContent() error {
+	var convs []Conversation
+	err := DB.Select("id", "history").Where("history_format = ?", HistoryFormatPrefixed).Find(&convs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, conv := range convs {
+		for i, m := range conv.History {
+			prefix := m.Sender + ": "
+			if m.Sender == "User" {
+				prefix = "User (Intervention): "
+			}
+			conv.History[i].Content = strings.TrimPrefix(m.Content, prefix)
+		}
+		// BeforeSave bumps history_format along with the update.
+		if err := DB.Model(&conv).Select("history", "history_format").Updates(&conv).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(convs) > 0 {
+		log.Printf("Converted %d conversations to raw history content", len(convs))
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package data
 
 import (
 	"errors"
+	"qigent/internal/llm"
 
 	"gorm.io/gorm"
 )
@@ -39,10 +40,16 @@ func CreateConversation(conv *Conversation) error {
 	return DB.Save(conv).Error
 }
 
-func GetConversations(userID uint) ([]Conversation, error) {
+// GetConversations lists a user's conversations, newest first. A non-empty
+// winner keeps only conversations whose verdict went to that participant.
+func GetConversations(userID uint, winner string) ([]Conversation, error) {
 	var convs []Conversation
 	// Preload? History is simpler if stored as JSON blob
-	err := DB.Where("user_id = ?", userID).Order("updated_at desc").Find(&convs).Error
+	q := DB.Where("user_id = ?", userID)
+	if winner != "" {
+		q = q.Where("winner = ?", winner)
+	}
+	err := q.Order("updated_at desc").Find(&convs).Error
 	return convs, err
 }
 
@@ -55,6 +62,13 @@ func GetConversation(id string) (*Conversation, error) {
 	return &conv, err
 }
 
+// GetForks returns the direct forks of a conversation, oldest first.
+func GetForks(parentID string, userID uint) ([]Conversation, error) {
+	var convs []Conversation
+	err := DB.Where("parent_id = ? AND user_id = ?", parentID, userID).Order("created_at asc").Find(&convs).Error
+	return convs, err
+}
+
 func SaveConversation(conv *Conversation) error {
 	// Save includes Create or Update
 	// Ensure all fields are saved
@@ -74,6 +88,17 @@ func GetRoles(userID uint) ([]Role, error) {
 	return roles, err
 }
 
+// GetRole finds a role visible to the user (their own or a system role),
+// preferring the user's own. It returns nil if there is none.
+func GetRole(name string, userID uint) (*Role, error) {
+	var role Role
+	err := DB.Where("name = ? AND (user_id = ? OR user_id = 0)", name, userID).Order("user_id desc").First(&role).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &role, err
+}
+
 func AddRole(role *Role) error {
 	return DB.Create(role).Error
 }
@@ -90,13 +115,17 @@ func SeedRoles() {
 		return
 	}
 
+	// The dreamer runs hot; without a profile it is clamped to what the
+	// connected provider allows.
+	dreamerTemp := 1.3
+
 	var count int64
 	DB.Model(&Role{}).Where("user_id = 0").Count(&count)
 	if count == 0 {
 		defaults := []Role{
 			{Name: "苏格拉底", Prompt: "你是一个苏格拉底式的哲学家，喜欢反问。", UserID: 0},
 			{Name: "乔布斯", Prompt: "你是一个追求极致产品体验的创新者。", UserID: 0},
-			{Name: "马斯克", Prompt: "你是一个疯狂的梦想家，思考第一性原理。", UserID: 0},
+			{Name: "马斯克", Prompt: "你是一个疯狂的梦想家，思考第一性原理。", UserID: 0, Sampling: llm.Sampling{Temperature: &dreamerTemp}},
 			{Name: "孔子", Prompt: "你是一位儒家圣人，讲究仁义礼智信。", UserID: 0},
 			{Name: "现代大学生", Prompt: "你是一个务实的现代大学生。", UserID: 0},
 		}
@@ -1,6 +1,10 @@
 package data
 
-import "gorm.io/gorm"
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
 
 // GetChatConfig retrieves the chat configuration for a user.
 // Returns a default config if none exists.
@@ -9,10 +13,10 @@ func GetChatConfig(userID uint) (*ChatConfig, error) {
 	err := DB.Where("user_id = ?", userID).First(&cfg).Error
 	if err != nil {
 		if err == gorm.ErrRecordNotFound {
-			// Return default config
+			// Return default config. BaseURL stays empty so the
+			// provider's own default is used, whichever is chosen.
 			return &ChatConfig{
 				UserID:   userID,
-				BaseURL:  "https://api.openai.com/v1",
 				LLMModel: "gpt-3.5-turbo",
 			}, nil
 		}
@@ -35,8 +39,51 @@ func SaveChatConfig(userID uint, cfg *ChatConfig) error {
 	}
 
 	// Update existing
+	existing.Provider = cfg.Provider
 	existing.APIKey = cfg.APIKey
 	existing.BaseURL = cfg.BaseURL
 	existing.LLMModel = cfg.LLMModel
+	existing.ContextWindow = cfg.ContextWindow
 	return DB.Save(&existing).Error
 }
+
+// GetProfiles lists a user's provider profiles by name.
+func GetProfiles(userID uint) ([]Profile, error) {
+	var profiles []Profile
+	err := DB.Where("user_id = ?", userID).Order("name").Find(&profiles).Error
+	return profiles, err
+}
+
+// GetProfile finds a user's profile by name. It returns nil if there is none.
+func GetProfile(name string, userID uint) (*Profile, error) {
+	var p Profile
+	err := DB.Where("name = ? AND user_id = ?", name, userID).First(&p).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	return &p, err
+}
+
+// SaveProfile creates a profile, or replaces the user's profile of the same name.
+func SaveProfile(userID uint, p *Profile) error {
+	p.UserID = userID
+	// Unscoped also finds a row deleted before deletes were permanent,
+	// which Save then restores.
+	var existing Profile
+	err := DB.Unscoped().Where("name = ? AND user_id = ?", p.Name, userID).First(&existing).Error
+	switch {
+	case err == nil:
+		p.ID = existing.ID
+		p.CreatedAt = existing.CreatedAt
+		p.DeletedAt = gorm.DeletedAt{}
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return err
+	}
+	return DB.Save(p).Error
+}
+
+// DeleteProfile removes a profile for good: a soft-deleted row would keep
+// its name in the unique index, so the name couldn't be used again.
+func DeleteProfile(name string, userID uint) error {
+	return DB.Unscoped().Where("name = ? AND user_id = ?", name, userID).Delete(&Profile{}).Error
+}
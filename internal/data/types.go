@@ -1,7 +1,10 @@
 package data
 
 import (
+	"fmt"
+	"qigent/internal/agent"
 	"qigent/internal/chat"
+	"qigent/internal/llm"
 	"time"
 
 	"gorm.io/gorm"
@@ -16,15 +19,129 @@ type User struct {
 type AgentConfig struct {
 	Name   string `json:"name"`
 	Prompt string `json:"prompt"`
+	// Role names a role-market entry whose prompt is copied in when Prompt is empty.
+	Role string `json:"role,omitempty"`
+	// Weight is the relative speaking chance under the weighted random policy.
+	Weight float64 `json:"weight,omitempty"`
+
+	// Profile names one of the user's provider profiles; empty uses the
+	// connection's settings. Model overrides the profile's model.
+	Profile string `json:"profile,omitempty"`
+	Model   string `json:"model,omitempty"`
+	// Sampling overrides the role's sampling settings, setting by setting.
+	Sampling llm.Sampling `json:"sampling"`
+}
+
+// MaxAgents caps the number of participants in a single conversation.
+const MaxAgents = 8
+
+// ValidateAgents checks that a participant list is usable by a room:
+// between 1 and MaxAgents entries, each with a unique, non-empty name.
+// Names must be unique because turn recovery matches history by sender.
+func ValidateAgents(agents []AgentConfig) error {
+	if len(agents) == 0 {
+		return fmt.Errorf("at least one agent is required")
+	}
+	if len(agents) > MaxAgents {
+		return fmt.Errorf("too many agents: %d (max %d)", len(agents), MaxAgents)
+	}
+	seen := make(map[string]bool, len(agents))
+	for i, a := range agents {
+		if a.Name == "" {
+			return fmt.Errorf("agent %d has no name", i+1)
+		}
+		if seen[a.Name] {
+			return fmt.Errorf("duplicate agent name: %s", a.Name)
+		}
+		seen[a.Name] = true
+	}
+	return nil
+}
+
+// ValidateModerator checks that a moderator's name doesn't clash with a participant,
+// which would confuse turn recovery.
+func ValidateModerator(mod *AgentConfig, agents []AgentConfig) error {
+	if mod == nil {
+		return nil
+	}
+	for _, a := range agents {
+		if a.Name == mod.Name {
+			return fmt.Errorf("moderator name %s clashes with an agent", mod.Name)
+		}
+	}
+	return nil
+}
+
+// ValidateFormat checks that a debate format only schedules participating agents.
+func ValidateFormat(format *chat.Format, agents []AgentConfig) error {
+	if format == nil {
+		return nil
+	}
+	names := make([]string, len(agents))
+	for i, a := range agents {
+		names[i] = a.Name
+	}
+	return format.Validate(names)
+}
+
+// Weights returns the per-agent weights in participant order.
+func (c *Conversation) Weights() []float64 {
+	weights := make([]float64, len(c.Agents))
+	for i, a := range c.Agents {
+		weights[i] = a.Weight
+	}
+	return weights
+}
+
+// Progress returns the state a history edit works on; SetProgress stores
+// the edited state back.
+func (c *Conversation) Progress() chat.Progress {
+	return chat.Progress{History: c.History, Summary: c.Summary, FormatStep: c.FormatStep, NextSpeaker: c.NextSpeaker, Notes: c.Notes}
+}
+
+func (c *Conversation) SetProgress(p chat.Progress) {
+	c.History, c.Summary, c.FormatStep, c.NextSpeaker, c.Notes = p.History, p.Summary, p.FormatStep, p.NextSpeaker, p.Notes
 }
 
 // ChatConfig stores the user's global chat settings
 type ChatConfig struct {
 	gorm.Model
 	UserID   uint   `json:"userId" gorm:"uniqueIndex"`
+	Provider string `json:"provider"` // One of the llm.Provider* types; empty means OpenAI-compatible
 	APIKey   string `json:"apiKey"`
 	BaseURL  string `json:"baseUrl"`
 	LLMModel string `json:"model"`
+	// ContextWindow overrides the model's context size in tokens; 0 looks it up by model.
+	ContextWindow int `json:"contextWindow"`
+}
+
+// Profile is a saved provider connection that participants and judges
+// can use by name, so one conversation can mix models from several providers.
+type Profile struct {
+	gorm.Model
+	UserID   uint   `json:"userId" gorm:"uniqueIndex:idx_profile_user"`
+	Name     string `json:"name" gorm:"uniqueIndex:idx_profile_user;size:191"`
+	Provider string `json:"provider"` // One of the llm.Provider* types
+	APIKey   string `json:"apiKey"`
+	BaseURL  string `json:"baseUrl"`
+	LLMModel string `json:"model"` // Default model; participants may override it
+	// ContextWindow overrides the model's context size in tokens; 0 looks it up by model.
+	ContextWindow int `json:"contextWindow"`
+	// Fallbacks are models to try, in order, when the model is rate
+	// limited or failing.
+	Fallbacks []string `json:"fallbacks" gorm:"serializer:json"`
+}
+
+// Config returns the client settings for the profile.
+func (p *Profile) Config() llm.Config {
+	return llm.Config{
+		Provider:      p.Provider,
+		BaseURL:       p.BaseURL,
+		APIKey:        p.APIKey,
+		Model:         p.LLMModel,
+		ContextWindow: p.ContextWindow,
+		Fallbacks:     p.Fallbacks,
+	}
 }
 
 // Stored as JSON in MySQL for simplicity in MVP, or normalized tables?
@@ -35,22 +152,95 @@ type Conversation struct {
 	ID     string `json:"id" gorm:"primaryKey;size:191"`
 	UserID uint   `json:"userId"`
 	Topic  string `json:"topic"`
-	Status string `json:"status"` // "active", "paused"
+	Status string `json:"status"` // "active", "paused", "finished"
 
 	// Embedded fields or JSON columns?
 	// For simplicity in GORM w/ MySQL, we can use `serializer:json`
-	AgentA  AgentConfig    `json:"agentA" gorm:"serializer:json"`
-	AgentB  AgentConfig    `json:"agentB" gorm:"serializer:json"`
+	// Agents is the ordered participant list; round-robin follows this order.
+	Agents  []AgentConfig  `json:"agents" gorm:"serializer:json"`
 	History []chat.Message `json:"history" gorm:"serializer:json"`
 
+	// TurnPolicy names the chat.TurnPolicy used to pick speakers; empty means round-robin.
+	TurnPolicy string `json:"turnPolicy"`
+	// Moderator is an optional LLM participant that picks speakers instead of TurnPolicy.
+	Moderator *AgentConfig `json:"moderator,omitempty" gorm:"serializer:json"`
+
+	// StopRules end the conversation automatically; Usage is what they are
+	// measured against, and StopReason records which rule fired.
+	StopRules  chat.StopRules `json:"stopRules" gorm:"serializer:json"`
+	Usage      chat.Usage     `json:"usage" gorm:"serializer:json"`
+	StopReason string         `json:"stopReason"`
+
+	// OnLastLeave decides what the running room does when its last viewer
+	// disconnects: one of chat.LeaveKeepRunning, LeavePause, LeaveStop.
+	OnLastLeave string `json:"onLastLeave"`
+
+	// Model overrides the model from the connection handshake, if set.
+	Model string `json:"model"`
+	// NameStyle controls how speaker names are shown to models (see llm.NameStyle).
+	NameStyle llm.NameStyle `json:"nameStyle"`
+
+	// Summary is the rolling memory that replaces older turns in prompts,
+	// refreshed every SummaryEvery agent turns (0 disables it).
+	Summary      chat.Summary `json:"summary" gorm:"serializer:json"`
+	SummaryEvery int          `json:"summaryEvery"`
+
+	// Format schedules a structured debate; FormatStep is the position in
+	// its schedule and Phase the name of the current phase.
+	Format     *chat.Format `json:"format,omitempty" gorm:"serializer:json"`
+	FormatStep int          `json:"formatStep"`
+	Phase      string       `json:"phase,omitempty"`
+	// NextSpeaker is the agent that speaks next when the conversation
+	// runs again, set when its turn was regenerated while stored.
+	NextSpeaker string `json:"nextSpeaker,omitempty"`
+
+	// Judge configures the judge; Verdict is its structured result, with
+	// Winner copied out into an indexed column for queries.
+	Judge   chat.JudgeConfig `json:"judge" gorm:"serializer:json"`
+	Verdict *chat.Verdict    `json:"verdict,omitempty" gorm:"serializer:json"`
+	Winner  string           `json:"winner,omitempty" gorm:"index;size:191"`
+
+	// Scratchpad lets agents keep private notes; Notes holds them by agent name.
+	Scratchpad bool                    `json:"scratchpad"`
+	Notes      map[string][]agent.Note `json:"notes,omitempty" gorm:"serializer:json"`
+
+	// Scenario and ScenarioVersion record the scenario file this
+	// conversation was started from, if any.
+	Scenario        string `json:"scenario,omitempty"`
+	ScenarioVersion int    `json:"scenarioVersion,omitempty"`
+
+	// HistoryFormat versions how History content is stored; see HistoryFormatRaw.
+	HistoryFormat int `json:"-"`
+
+	// Forks record where they branched off: the parent conversation and the
+	// number of parent history messages they started with.
+	ParentID    string `json:"parentId,omitempty" gorm:"index;size:191"`
+	BranchPoint int    `json:"branchPoint,omitempty"`
+
 	CreatedAt time.Time `json:"createdAt"`
 	UpdatedAt time.Time `json:"updatedAt"`
 }
 
+// History content formats.
+const (
+	HistoryFormatPrefixed = 0 // Legacy: content stored as "Sender: text"
+	HistoryFormatRaw      = 1 // Content is the raw text; the sender lives in Sender
+)
+
+// BeforeSave stamps the current history format. Legacy rows are converted by
+// migrateRawContent at startup, before anything can load and re-save them.
+func (c *Conversation) BeforeSave(tx *gorm.DB) error {
+	c.HistoryFormat = HistoryFormatRaw
+	return nil
+}
+
 type Role struct {
 	gorm.Model
 	UserID uint   `json:"userId"` // 0 for public/system roles?
 	Name   string `json:"name" gorm:"uniqueIndex:idx_name_user;size:191"`
 	Prompt string `json:"prompt"`
 	Avatar string `json:"avatar"`
+	// Sampling is the role's default generation settings; participants
+	// playing the role may override them.
+	Sampling llm.Sampling `json:"sampling" gorm:"serializer:json"`
 }
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"fmt"
+	"qigent/internal/chat"
+	"qigent/internal/llm"
+)
+
+// Resolve fills in what a new conversation references by name: role-market
+// prompts for agents and judges, built-in debate formats, and the default
+// moderator name. It also checks that referenced provider profiles exist.
+// Roles and profiles are looked up for c.UserID.
+func (c *Conversation) Resolve() error {
+	if err := ResolveAgents(c.Agents, c.UserID); err != nil {
+		return err
+	}
+	if c.Moderator != nil && c.Moderator.Name == "" {
+		c.Moderator.Name = chat.ModeratorName
+	}
+	if c.Moderator != nil {
+		if err := checkProfile(c.Moderator.Profile, c.Moderator.Sampling, c.UserID); err != nil {
+			return fmt.Errorf("moderator: %w", err)
+		}
+	}
+	if c.Format != nil {
+		if err := c.Format.Resolve(); err != nil {
+			return err
+		}
+	}
+	return ResolveJudge(&c.Judge, c.UserID)
+}
+
+// ResolveAgents copies role-market prompts and sampling settings into agents
+// that reference a role, and checks their provider profiles exist.
+func ResolveAgents(agents []AgentConfig, userID uint) error {
+	for i := range agents {
+		if err := resolveRole(&agents[i].Prompt, &agents[i].Sampling, agents[i].Role, userID); err != nil {
+			return fmt.Errorf("agent %s: %w", agents[i].Name, err)
+		}
+		if err := checkProfile(agents[i].Profile, agents[i].Sampling, userID); err != nil {
+			return fmt.Errorf("agent %s: %w", agents[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// ResolveJudge copies role-market prompts and sampling settings into a judge
+// config and its panelists, and checks their provider profiles exist.
+func ResolveJudge(j *chat.JudgeConfig, userID uint) error {
+	for i := range j.Panel {
+		if err := ResolveJudge(&j.Panel[i], userID); err != nil {
+			return err
+		}
+	}
+	if err := resolveRole(&j.Prompt, &j.Sampling, j.Role, userID); err != nil {
+		return fmt.Errorf("judge: %w", err)
+	}
+	if err := checkProfile(j.Profile, j.Sampling, userID); err != nil {
+		return fmt.Errorf("judge: %w", err)
+	}
+	return nil
+}
+
+// checkProfile rejects a reference to a provider profile the user doesn't
+// have, and sampling settings the profile's provider doesn't accept. Without
+// a profile the provider is only known at connect time, so sampling is
+// checked against the OpenAI-compatible ranges.
+func checkProfile(name string, sampling llm.Sampling, userID uint) error {
+	if name == "" {
+		return sampling.Validate(llm.ProviderOpenAI)
+	}
+	p, err := GetProfile(name, userID)
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		return fmt.Errorf("unknown provider profile: %s", name)
+	}
+	return sampling.Validate(p.Provider)
+}
+
+// resolveRole fills in the named role's prompt, if prompt is empty, and its
+// sampling settings under those already in sampling.
+func resolveRole(prompt *string, sampling *llm.Sampling, name string, userID uint) error {
+	if name == "" {
+		return nil
+	}
+	role, err := GetRole(name, userID)
+	if err != nil {
+		return err
+	}
+	if role == nil {
+		if *prompt != "" {
+			// The prompt was given; the role is only a label.
+			return nil
+		}
+		return fmt.Errorf("unknown role: %s", name)
+	}
+	if *prompt == "" {
+		*prompt = role.Prompt
+	}
+	*sampling = role.Sampling.Merge(*sampling)
+	return nil
+}
+
+// Validate checks a conversation's settings before it is created.
+func (c *Conversation) Validate() error {
+	if err := ValidateAgents(c.Agents); err != nil {
+		return err
+	}
+	if _, err := chat.NewTurnPolicy(c.TurnPolicy, nil); err != nil {
+		return err
+	}
+	if err := ValidateModerator(c.Moderator, c.Agents); err != nil {
+		return err
+	}
+	if err := c.StopRules.Validate(); err != nil {
+		return err
+	}
+	if !chat.ValidLeavePolicy(c.OnLastLeave) {
+		return fmt.Errorf("unknown onLastLeave policy: %s", c.OnLastLeave)
+	}
+	if !llm.ValidNameStyle(c.NameStyle) {
+		return fmt.Errorf("unknown nameStyle: %s", c.NameStyle)
+	}
+	if c.SummaryEvery < 0 {
+		return fmt.Errorf("summaryEvery must not be negative")
+	}
+	if err := c.Judge.Validate(); err != nil {
+		return err
+	}
+	return ValidateFormat(c.Format, c.Agents)
+}
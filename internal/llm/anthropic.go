@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// anthropicVersion is the Messages API version this adapter speaks.
+const anthropicVersion = "2023-06-01"
+
+// Anthropic is the Provider for the Anthropic Messages API.
+type Anthropic struct {
+	base
+}
+
+// NewAnthropic creates an Anthropic Messages API client.
+func NewAnthropic(config Config) *Anthropic {
+	return &Anthropic{newBase(config, "https://api.anthropic.com/v1")}
+}
+
+type anthropicRequest struct {
+	Model     string        `json:"model"`
+	System    string        `json:"system,omitempty"`
+	Messages  []ChatMessage `json:"messages"`
+	MaxTokens int           `json:"max_tokens"`
+	Stream    bool          `json:"stream"`
+
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	StopSequences []string `json:"stop_sequences,omitempty"`
+}
+
+// anthropicEvent is the data of one streamed event; only the fields
+// needed for text, the stop reason and usage are decoded.
+type anthropicEvent struct {
+	Type  string `json:"type"`
+	Delta struct {
+		Type       string `json:"type"`
+		Text       string `json:"text"`
+		StopReason string `json:"stop_reason"`
+	} `json:"delta"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"` // message_start
+	Usage anthropicUsage `json:"usage"` // message_delta
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// ChatStream sends a streaming Messages request. System messages become the
+// system prompt and speaker names are folded into the text.
+func (a *Anthropic) ChatStream(ctx context.Context, messages []ChatMessage) (*Stream, error) {
+	system, rest := splitSystem(messages)
+	reqBody := anthropicRequest{
+		Model:    a.config.Model,
+		System:   system,
+		Messages: rest,
+		// Required by the API; the reply reserve is what we left room for.
+		MaxTokens:     a.replyReserve(),
+		Stream:        true,
+		Temperature:   a.config.Sampling.Temperature,
+		TopP:          a.config.Sampling.TopP,
+		StopSequences: a.config.Sampling.Stop,
+	}
+
+	header := http.Header{}
+	header.Set("x-api-key", a.config.APIKey)
+	header.Set("anthropic-version", anthropicVersion)
+	resp, err := a.post(ctx, a.config.BaseURL+"/messages", reqBody, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// Input tokens come with message_start, output tokens with message_delta.
+	var usage TokenUsage
+	return streamLines(ctx, resp.Body, a.config.IdleTimeout, func(line string, res *Result) (string, bool) {
+		data, ok := sseData(line)
+		if !ok {
+			return "", false
+		}
+		var ev anthropicEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			decodeError(res, err)
+			return "", true
+		}
+		switch ev.Type {
+		case "message_start":
+			usage.PromptTokens = ev.Message.Usage.InputTokens
+		case "content_block_delta":
+			if ev.Delta.Type == "text_delta" {
+				return ev.Delta.Text, false
+			}
+		case "message_delta":
+			res.FinishReason = finishReason(ev.Delta.StopReason)
+			usage.CompletionTokens = ev.Usage.OutputTokens
+			res.Usage = &usage
+		case "error":
+			res.Err = streamError(ev.Error.Type, ev.Error.Message)
+			return "", true
+		case "message_stop":
+			return "", true
+		}
+		return "", false
+	}), nil
+}
+
+// Chat collects the whole streamed reply.
+func (a *Anthropic) Chat(ctx context.Context, messages []ChatMessage) (string, error) {
+	return collect(ctx, a, messages)
+}
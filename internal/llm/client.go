@@ -1,48 +1,51 @@
 package llm
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
-	"io"
 	"net/http"
-	"strings"
+	"time"
 )
 
 // Config holds the configuration for the LLM API.
 type Config struct {
-	BaseURL string
-	APIKey  string
-	Model   string
+	// Provider picks the API dialect (see New); empty means ProviderOpenAI.
+	Provider string
+	BaseURL  string
+	APIKey   string
+	Model    string
+
+	// ContextWindow overrides the model's context size in tokens (0 looks it up by model).
+	ContextWindow int
+	// ReplyReserve is kept free for the reply (0 means DefaultReplyReserve).
+	ReplyReserve int
+	// Tokenizer counts prompt tokens (nil means Estimator).
+	Tokenizer Tokenizer
+	// Sampling is sent with every request.
+	Sampling Sampling
+	// IdleTimeout ends a stream that sends nothing for this long (0 means
+	// DefaultIdleTimeout).
+	IdleTimeout time.Duration
+	// Fallbacks are models of the same provider to try, in order, when
+	// Model is rate limited, failing or can't take the prompt.
+	Fallbacks []string
 }
 
-// Client handles communication with the LLM provider.
+// Client is the Provider for OpenAI-compatible /chat/completions APIs.
 type Client struct {
-	config     Config
-	httpClient *http.Client
+	base
 }
 
 // NewClient creates a new LLM client.
 func NewClient(config Config) *Client {
-	if config.BaseURL == "" {
-		config.BaseURL = "https://api.openai.com/v1"
-	}
-	return &Client{
-		config: config,
-		httpClient: &http.Client{
-			// Longer timeout for streaming sessions if needed,
-			// though usually http.Client.Do timeout covers the initial connection.
-			// For streaming, we might rely on loop cancellation.
-			Timeout: 0, // No global timeout for streaming
-		},
-	}
+	return &Client{newBase(config, "https://api.openai.com/v1")}
 }
 
 // ChatMessage represents a message in the LLM conversation.
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
+	Name    string `json:"name,omitempty"`
 }
 
 // ChatRequest represents the payload sent to the API.
@@ -50,6 +53,22 @@ type ChatRequest struct {
 	Model    string        `json:"model"`
 	Messages []ChatMessage `json:"messages"`
 	Stream   bool          `json:"stream"`
+
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	MaxTokens        *int     `json:"max_tokens,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+}
+
+// StreamOptions asks for extras in the stream.
+type StreamOptions struct {
+	// IncludeUsage adds a final chunk with the request's token usage.
+	IncludeUsage bool `json:"include_usage"`
 }
 
 // ChatCompletionChunk represents the streaming response chunk.
@@ -58,107 +77,80 @@ type ChatCompletionChunk struct {
 		Delta struct {
 			Content string `json:"content"`
 		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
 }
 
 // ChatStream sends a streaming chat completion request.
-// It returns a channel that emits chunks of text, and an error if the request setup fails.
-func (c *Client) ChatStream(systemPrompt string, history []string) (<-chan string, error) {
-	// Construct messages
-	var messages []ChatMessage
-	messages = append(messages, ChatMessage{Role: "system", Content: systemPrompt})
-
-	valuableHistory := history
-	// Simple rolling window to avoid exceeding context too quickly (MVP hack)
-	if len(history) > 10 {
-		valuableHistory = history[len(history)-10:]
-	}
-
-	for _, h := range valuableHistory {
-		messages = append(messages, ChatMessage{Role: "user", Content: h})
-	}
-
+// messages are sent as-is; use Messages to build them within the context window.
+// It returns a stream of text chunks, and an error if the request setup fails.
+// Cancelling ctx aborts the upstream HTTP request and closes the stream.
+func (c *Client) ChatStream(ctx context.Context, messages []ChatMessage) (*Stream, error) {
+	sp := c.config.Sampling
 	reqBody := ChatRequest{
 		Model:    c.config.Model,
 		Messages: messages,
 		Stream:   true,
-	}
 
-	jsonData, err := json.Marshal(reqBody)
-	if err != nil {
-		return nil, err
-	}
+		Temperature:      sp.Temperature,
+		TopP:             sp.TopP,
+		MaxTokens:        sp.MaxTokens,
+		PresencePenalty:  sp.PresencePenalty,
+		FrequencyPenalty: sp.FrequencyPenalty,
+		Stop:             sp.Stop,
+		Seed:             sp.Seed,
 
-	url := c.config.BaseURL + "/chat/completions"
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
-	if err != nil {
-		return nil, err
+		StreamOptions: &StreamOptions{IncludeUsage: true},
 	}
 
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.config.APIKey)
-
-	// Use a separate client or override timeout for streaming if needed
-	resp, err := c.httpClient.Do(req)
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+c.config.APIKey)
+	resp, err := c.post(ctx, c.config.BaseURL+"/chat/completions", reqBody, header)
 	if err != nil {
 		return nil, err
 	}
 
-	if resp.StatusCode != 200 {
-		body, _ := io.ReadAll(resp.Body)
-		resp.Body.Close()
-		return nil, fmt.Errorf("API error: %s - %s", resp.Status, string(body))
-	}
-
-	out := make(chan string)
-
-	go func() {
-		defer resp.Body.Close()
-		defer close(out)
-
-		reader := bufio.NewReader(resp.Body)
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err != io.EOF {
-					// Log error? For MVP just stop.
-				}
-				return
-			}
-
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-
-			if !strings.HasPrefix(line, "data: ") {
-				continue
-			}
-
-			data := strings.TrimPrefix(line, "data: ")
-			if data == "[DONE]" {
-				return
-			}
-
-			var chunk ChatCompletionChunk
-			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
-				continue
-			}
-
-			if len(chunk.Choices) > 0 {
-				content := chunk.Choices[0].Delta.Content
-				if content != "" {
-					out <- content
-				}
-			}
+	return streamLines(ctx, resp.Body, c.config.IdleTimeout, func(line string, res *Result) (string, bool) {
+		data, ok := sseData(line)
+		if !ok {
+			return "", false
+		}
+		if data == "[DONE]" {
+			return "", true
 		}
-	}()
 
-	return out, nil
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			decodeError(res, err)
+			return "", true
+		}
+		if chunk.Error != nil {
+			res.Err = streamError(chunk.Error.Type, chunk.Error.Message)
+			return "", true
+		}
+		if u := chunk.Usage; u != nil {
+			res.Usage = &TokenUsage{PromptTokens: u.PromptTokens, CompletionTokens: u.CompletionTokens}
+		}
+		if len(chunk.Choices) == 0 {
+			return "", false
+		}
+		if r := chunk.Choices[0].FinishReason; r != "" {
+			res.FinishReason = finishReason(r)
+		}
+		return chunk.Choices[0].Delta.Content, false
+	}), nil
 }
 
-// Chat (Non-streaming) - kept for compatibility if needed, but we focus on Stream
-func (c *Client) Chat(systemPrompt string, history []string) (string, error) {
-	// ... (Implementation omitted for brevity, focusing on Stream)
-	return "", fmt.Errorf("use ChatStream instead")
+// Chat is the non-streaming variant: it collects the whole stream into one string.
+// Used for short structured calls (e.g. the moderator) rather than live output.
+func (c *Client) Chat(ctx context.Context, messages []ChatMessage) (string, error) {
+	return collect(ctx, c, messages)
 }
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies a failed model request.
+type ErrorKind string
+
+const (
+	KindRateLimit     ErrorKind = "rate_limit"     // Too many requests; retry later
+	KindAuth          ErrorKind = "auth"           // Bad or missing API key
+	KindContextLength ErrorKind = "context_length" // The prompt doesn't fit the model
+	KindServer        ErrorKind = "server"         // The provider failed or is overloaded
+	KindNetwork       ErrorKind = "network"        // The request didn't get a response
+	KindRequest       ErrorKind = "request"        // Any other rejected request
+)
+
+// APIError is a failed model request.
+type APIError struct {
+	Kind       ErrorKind
+	StatusCode int    // 0 for network errors
+	Status     string // e.g. "429 Too Many Requests"
+	Body       string
+	// RetryAfter is how long the server asked us to wait, if it did.
+	RetryAfter time.Duration
+	// Err is the transport error behind a network error.
+	Err error
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("API error: %s - %s", e.Status, e.Body)
+}
+
+func (e *APIError) Unwrap() error { return e.Err }
+
+// Retryable reports whether the same request may succeed later.
+func (e *APIError) Retryable() bool {
+	switch e.Kind {
+	case KindRateLimit, KindServer, KindNetwork:
+		return true
+	}
+	return false
+}
+
+// newAPIError classifies a non-200 response.
+func newAPIError(resp *http.Response, body string) *APIError {
+	e := &APIError{StatusCode: resp.StatusCode, Status: resp.Status, Body: body}
+	switch code := resp.StatusCode; {
+	case code == http.StatusTooManyRequests:
+		e.Kind = KindRateLimit
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		e.Kind = KindAuth
+	case code == http.StatusRequestTimeout, code >= 500:
+		e.Kind = KindServer
+	case contextLengthError(body):
+		e.Kind = KindContextLength
+	default:
+		e.Kind = KindRequest
+	}
+	e.RetryAfter = retryAfter(resp.Header.Get("Retry-After"))
+	return e
+}
+
+// contextLengthError recognises each provider's "prompt too long" message.
+func contextLengthError(body string) bool {
+	body = strings.ToLower(body)
+	for _, s := range []string{"context_length_exceeded", "maximum context length", "context window", "prompt is too long", "exceeds the maximum number of tokens"} {
+		if strings.Contains(body, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// Classify returns the kind of a failed request, or "" if err isn't one
+// (e.g. a cancelled context).
+func Classify(err error) ErrorKind {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return ""
+}
+
+// Retryable reports whether err is a failed request worth retrying.
+func Retryable(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Retryable()
+}
+
+// networkError wraps a transport error, unless it came from ctx ending.
+func networkError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return err
+	}
+	return &APIError{Kind: KindNetwork, Err: err}
+}
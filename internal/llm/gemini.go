@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Gemini is the Provider for the Gemini API's streamGenerateContent.
+type Gemini struct {
+	base
+}
+
+// NewGemini creates a Gemini API client.
+func NewGemini(config Config) *Gemini {
+	return &Gemini{newBase(config, "https://generativelanguage.googleapis.com/v1beta")}
+}
+
+type geminiPart struct {
+	Text string `json:"text"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiRequest struct {
+	SystemInstruction *geminiContent   `json:"systemInstruction,omitempty"`
+	Contents          []geminiContent  `json:"contents"`
+	GenerationConfig  geminiGeneration `json:"generationConfig"`
+}
+
+type geminiGeneration struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	MaxOutputTokens  *int     `json:"maxOutputTokens,omitempty"`
+	PresencePenalty  *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequencyPenalty,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+}
+
+type geminiChunk struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata *struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+	Error *struct {
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+// ChatStream sends a streaming generateContent request. System messages
+// become the system instruction, the assistant role is Gemini's "model",
+// and speaker names are folded into the text.
+func (g *Gemini) ChatStream(ctx context.Context, messages []ChatMessage) (*Stream, error) {
+	system, rest := splitSystem(messages)
+	sp := g.config.Sampling
+	reqBody := geminiRequest{
+		GenerationConfig: geminiGeneration{
+			Temperature:      sp.Temperature,
+			TopP:             sp.TopP,
+			MaxOutputTokens:  sp.MaxTokens,
+			PresencePenalty:  sp.PresencePenalty,
+			FrequencyPenalty: sp.FrequencyPenalty,
+			StopSequences:    sp.Stop,
+			Seed:             sp.Seed,
+		},
+	}
+	if system != "" {
+		reqBody.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: system}}}
+	}
+	for _, m := range rest {
+		role := "user"
+		if m.Role == "assistant" {
+			role = "model"
+		}
+		reqBody.Contents = append(reqBody.Contents, geminiContent{Role: role, Parts: []geminiPart{{Text: m.Content}}})
+	}
+
+	header := http.Header{}
+	header.Set("x-goog-api-key", g.config.APIKey)
+	endpoint := g.config.BaseURL + "/models/" + url.PathEscape(g.config.Model) + ":streamGenerateContent?alt=sse"
+	resp, err := g.post(ctx, endpoint, reqBody, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// The stream simply ends after the last candidate, which carries the
+	// finish reason.
+	return streamLines(ctx, resp.Body, g.config.IdleTimeout, func(line string, res *Result) (string, bool) {
+		data, ok := sseData(line)
+		if !ok {
+			return "", false
+		}
+		var chunk geminiChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			decodeError(res, err)
+			return "", true
+		}
+		if chunk.Error != nil {
+			res.Err = streamError(chunk.Error.Status, chunk.Error.Message)
+			return "", true
+		}
+		if u := chunk.UsageMetadata; u != nil {
+			res.Usage = &TokenUsage{PromptTokens: u.PromptTokenCount, CompletionTokens: u.CandidatesTokenCount}
+		}
+		if len(chunk.Candidates) == 0 {
+			return "", false
+		}
+		if r := chunk.Candidates[0].FinishReason; r != "" {
+			res.FinishReason = finishReason(r)
+		}
+		var sb strings.Builder
+		for _, p := range chunk.Candidates[0].Content.Parts {
+			sb.WriteString(p.Text)
+		}
+		return sb.String(), false
+	}), nil
+}
+
+// Chat collects the whole streamed reply.
+func (g *Gemini) Chat(ctx context.Context, messages []ChatMessage) (string, error) {
+	return collect(ctx, g, messages)
+}
@@ -0,0 +1,87 @@
+package llm
+
+import "strings"
+
+// NameStyle controls how speaker names reach the model.
+type NameStyle string
+
+const (
+	NamePrefix NameStyle = "prefix" // "Alice: text" in the message content (default)
+	NameField  NameStyle = "field"  // OpenAI's per-message "name" field
+	NameNone   NameStyle = "none"   // Roles only, no names
+)
+
+// ValidNameStyle reports whether s is a known style ("" means NamePrefix).
+func ValidNameStyle(s NameStyle) bool {
+	switch s {
+	case "", NamePrefix, NameField, NameNone:
+		return true
+	}
+	return false
+}
+
+// Turn is one transcript entry: who said it, and the raw text.
+// An empty Speaker marks an instruction rather than a participant's turn.
+type Turn struct {
+	Speaker string
+	Content string
+	Pinned  bool // Never dropped by FitTurns (e.g. the topic)
+}
+
+// BuildMessages maps a shared transcript onto chat roles from self's point
+// of view: self's own turns become "assistant" (never name-tagged, so the
+// model doesn't learn to prefix itself), everyone else's become "user".
+// An empty self (e.g. a judge) makes every turn a "user" message.
+func BuildMessages(systemPrompt, self string, turns []Turn, style NameStyle) []ChatMessage {
+	messages := make([]ChatMessage, 0, len(turns)+1)
+	if systemPrompt != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: systemPrompt})
+	}
+
+	for _, t := range turns {
+		if self != "" && t.Speaker == self {
+			messages = append(messages, ChatMessage{Role: "assistant", Content: t.Content})
+			continue
+		}
+
+		msg := ChatMessage{Role: "user", Content: t.Content}
+		if t.Speaker != "" {
+			switch style {
+			case NameNone:
+			case NameField:
+				if name := fieldName(t.Speaker); name != "" {
+					msg.Name = name
+				} else {
+					// Not representable in the name field (e.g. CJK); fall back to a prefix.
+					msg.Content = t.Speaker + ": " + t.Content
+				}
+			default:
+				msg.Content = t.Speaker + ": " + t.Content
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages
+}
+
+// fieldName reduces a speaker name to what OpenAI accepts in "name"
+// ([A-Za-z0-9_-], at most 64 chars). Returns "" if nothing usable is left.
+func fieldName(speaker string) string {
+	var sb strings.Builder
+	for _, r := range speaker {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			sb.WriteRune(r)
+		case r == ' ':
+			sb.WriteRune('_')
+		}
+	}
+	name := sb.String()
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	if strings.Trim(name, "_-") == "" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,108 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// Ollama is the Provider for Ollama's native /api/chat, which streams
+// newline-delimited JSON rather than server-sent events.
+type Ollama struct {
+	base
+}
+
+// NewOllama creates an Ollama client. BaseURL is the server root, e.g.
+// http://localhost:11434 (the default).
+func NewOllama(config Config) *Ollama {
+	return &Ollama{newBase(config, "http://localhost:11434")}
+}
+
+type ollamaRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+	Options  ollamaOptions `json:"options"`
+}
+
+// ollamaOptions are Ollama's sampling settings.
+type ollamaOptions struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"top_p,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	PresencePenalty  *float64 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequency_penalty,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+}
+
+type ollamaChunk struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason"`
+	// Token counts, on the final chunk.
+	PromptEvalCount int    `json:"prompt_eval_count"`
+	EvalCount       int    `json:"eval_count"`
+	Error           string `json:"error"`
+}
+
+// ChatStream sends a streaming chat request. Speaker names are folded into
+// the text.
+func (o *Ollama) ChatStream(ctx context.Context, messages []ChatMessage) (*Stream, error) {
+	msgs := make([]ChatMessage, len(messages))
+	for i, m := range messages {
+		msgs[i] = ChatMessage{Role: m.Role, Content: namedContent(m)}
+	}
+	sp := o.config.Sampling
+	reqBody := ollamaRequest{
+		Model:    o.config.Model,
+		Messages: msgs,
+		Stream:   true,
+		Options: ollamaOptions{
+			Temperature:      sp.Temperature,
+			TopP:             sp.TopP,
+			NumPredict:       sp.MaxTokens,
+			PresencePenalty:  sp.PresencePenalty,
+			FrequencyPenalty: sp.FrequencyPenalty,
+			Stop:             sp.Stop,
+			Seed:             sp.Seed,
+		},
+	}
+
+	// Ollama needs no key, but proxies in front of it may.
+	header := http.Header{}
+	if o.config.APIKey != "" {
+		header.Set("Authorization", "Bearer "+o.config.APIKey)
+	}
+	resp, err := o.post(ctx, o.config.BaseURL+"/api/chat", reqBody, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return streamLines(ctx, resp.Body, o.config.IdleTimeout, func(line string, res *Result) (string, bool) {
+		var chunk ollamaChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			decodeError(res, err)
+			return "", true
+		}
+		if chunk.Error != "" {
+			res.Err = streamError("error", chunk.Error)
+			return "", true
+		}
+		if chunk.Done {
+			res.FinishReason = finishReason(chunk.DoneReason)
+			if res.FinishReason == "" {
+				res.FinishReason = FinishStop // Older servers don't say why
+			}
+			res.Usage = &TokenUsage{PromptTokens: chunk.PromptEvalCount, CompletionTokens: chunk.EvalCount}
+		}
+		return chunk.Message.Content, chunk.Done
+	}), nil
+}
+
+// Chat collects the whole streamed reply.
+func (o *Ollama) Chat(ctx context.Context, messages []ChatMessage) (string, error) {
+	return collect(ctx, o, messages)
+}
@@ -0,0 +1,223 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Provider is a model API. Agents, the moderator and the judge talk to
+// models only through it; each adapter speaks one API dialect.
+type Provider interface {
+	// ChatStream streams the reply to messages. The stream's channel closes
+	// when the reply ends, and its Result says how; cancelling ctx aborts
+	// the request and closes it too.
+	ChatStream(ctx context.Context, messages []ChatMessage) (*Stream, error)
+	// Chat waits for the whole reply.
+	Chat(ctx context.Context, messages []ChatMessage) (string, error)
+	// Messages builds a prompt that fits the model's context window (see Client.Messages).
+	Messages(systemPrompt, self string, turns []Turn, style NameStyle) []ChatMessage
+	// Tokenizer counts prompt tokens for the model.
+	Tokenizer() Tokenizer
+}
+
+// Provider types, as stored in Config.Provider.
+const (
+	ProviderOpenAI    = "openai" // OpenAI-compatible /chat/completions (the default)
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+	ProviderGemini    = "gemini"
+)
+
+// ValidProvider reports whether p names a known provider type ("" means ProviderOpenAI).
+func ValidProvider(p string) bool {
+	switch p {
+	case "", ProviderOpenAI, ProviderAnthropic, ProviderOllama, ProviderGemini:
+		return true
+	}
+	return false
+}
+
+// New creates the adapter for config.Provider, falling back to
+// config.Fallbacks in order when the model fails. It fails if the provider
+// is unknown or doesn't accept config.Sampling.
+func New(config Config) (Provider, error) {
+	if !ValidProvider(config.Provider) {
+		return nil, fmt.Errorf("unknown provider: %s", config.Provider)
+	}
+	if err := config.Sampling.Validate(config.Provider); err != nil {
+		return nil, err
+	}
+	if len(config.Fallbacks) > 0 {
+		f := &fallback{Provider: newAdapter(config)}
+		for _, model := range config.Fallbacks {
+			cfg := config
+			cfg.Model = model
+			f.next = append(f.next, newAdapter(cfg))
+		}
+		return f, nil
+	}
+	return newAdapter(config), nil
+}
+
+func newAdapter(config Config) Provider {
+	switch config.Provider {
+	case ProviderAnthropic:
+		return NewAnthropic(config)
+	case ProviderOllama:
+		return NewOllama(config)
+	case ProviderGemini:
+		return NewGemini(config)
+	}
+	return NewClient(config)
+}
+
+// base is what every adapter shares: configuration, the HTTP client and
+// prompt sizing.
+type base struct {
+	config     Config
+	httpClient *http.Client
+}
+
+func newBase(config Config, defaultURL string) base {
+	if config.BaseURL == "" {
+		config.BaseURL = defaultURL
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	// No global timeout: streams run as long as the turn; cancellation ends them.
+	return base{config: config, httpClient: &http.Client{}}
+}
+
+// Tokenizer returns the tokenizer used to size prompts for this client.
+func (b *base) Tokenizer() Tokenizer {
+	if b.config.Tokenizer == nil {
+		return Estimator
+	}
+	return b.config.Tokenizer
+}
+
+// PromptBudget is how many tokens a prompt may use: the model's context
+// window minus the reply reserve.
+func (b *base) PromptBudget() int {
+	window := b.config.ContextWindow
+	if window <= 0 {
+		window = ContextWindow(b.config.Model)
+	}
+	return window - b.replyReserve()
+}
+
+// replyReserve is the sampling's max tokens if set, since the reply can't
+// be longer than that.
+func (b *base) replyReserve() int {
+	if m := b.config.Sampling.MaxTokens; m != nil {
+		return *m
+	}
+	if b.config.ReplyReserve <= 0 {
+		return DefaultReplyReserve
+	}
+	return b.config.ReplyReserve
+}
+
+// Messages maps a transcript onto roles (see BuildMessages), keeping only
+// as much of it as fits this client's context window next to the system prompt.
+func (b *base) Messages(systemPrompt, self string, turns []Turn, style NameStyle) []ChatMessage {
+	tok := b.Tokenizer()
+	budget := b.PromptBudget() - messageOverhead - tok.CountTokens(systemPrompt)
+	return BuildMessages(systemPrompt, self, FitTurns(turns, budget, tok), style)
+}
+
+// post sends body as JSON and returns the response if it is a 200. Failed
+// requests are returned as an *APIError.
+func (b *base) post(ctx context.Context, url string, body any, header http.Header) (*http.Response, error) {
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return nil, networkError(ctx, err)
+	}
+	if resp.StatusCode != 200 {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, newAPIError(resp, string(body))
+	}
+	return resp, nil
+}
+
+// sseData returns the payload of a server-sent event "data:" line.
+func sseData(line string) (string, bool) {
+	data, ok := strings.CutPrefix(line, "data:")
+	return strings.TrimSpace(data), ok
+}
+
+// collect is Chat for any adapter: it gathers the whole stream into one
+// string. A reply that didn't finish is returned along with the reason.
+func collect(ctx context.Context, p Provider, messages []ChatMessage) (string, error) {
+	stream, err := p.ChatStream(ctx, messages)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for chunk := range stream.C {
+		sb.WriteString(chunk)
+	}
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return sb.String(), stream.Result().Incomplete()
+}
+
+// namedContent folds a message's speaker name into its text, for APIs
+// without a name field.
+func namedContent(m ChatMessage) string {
+	if m.Name == "" {
+		return m.Content
+	}
+	return m.Name + ": " + m.Content
+}
+
+// splitSystem separates system messages, joined into one prompt, from the
+// conversation, for APIs that take the system prompt on its own. Adjacent
+// messages with the same role are merged, since those APIs expect turns
+// to alternate.
+func splitSystem(messages []ChatMessage) (string, []ChatMessage) {
+	var system []string
+	var rest []ChatMessage
+	for _, m := range messages {
+		if m.Role == "system" {
+			system = append(system, m.Content)
+			continue
+		}
+		content := namedContent(m)
+		if n := len(rest); n > 0 && rest[n-1].Role == m.Role {
+			rest[n-1].Content += "\n\n" + content
+			continue
+		}
+		rest = append(rest, ChatMessage{Role: m.Role, Content: content})
+	}
+	// Ending on the model's own turn (an agent alone in a room, say) would
+	// read as a reply to continue, or be refused; ask for the next turn.
+	if n := len(rest); n == 0 || rest[n-1].Role != "user" {
+		rest = append(rest, ChatMessage{Role: "user", Content: continuePrompt})
+	}
+	return strings.Join(system, "\n\n"), rest
+}
+
+// continuePrompt asks for a new turn when the conversation doesn't end on
+// someone else's.
+const continuePrompt = "(Continue the discussion with your next turn.)"
@@ -0,0 +1,119 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// The fixtures in testdata are recorded responses of each API to a short
+// prompt; the server replays them and records what the adapter sent.
+func TestProvidersAgainstFixtures(t *testing.T) {
+	cases := []struct {
+		provider string
+		fixture  string
+		path     string
+		header   string // Header that must carry the API key
+		prefix   string // Expected header value before the key
+		check    func(t *testing.T, body map[string]any)
+	}{
+		{ProviderOpenAI, "openai.sse", "/chat/completions", "Authorization", "Bearer ", func(t *testing.T, body map[string]any) {
+			if msgs := body["messages"].([]any); len(msgs) != 3 {
+				t.Errorf("sent %d messages, want 3", len(msgs))
+			}
+		}},
+		{ProviderAnthropic, "anthropic.sse", "/messages", "X-Api-Key", "", func(t *testing.T, body map[string]any) {
+			if body["system"] != "Be brief." || body["max_tokens"] == nil {
+				t.Errorf("system/max_tokens not sent: %v", body)
+			}
+			msgs := body["messages"].([]any)
+			if first := msgs[0].(map[string]any); len(msgs) != 3 || first["content"] != "Mod: Topic?" {
+				t.Errorf("messages = %v, want the name folded in and no system message", msgs)
+			}
+			if last := msgs[2].(map[string]any); last["role"] != "user" {
+				t.Errorf("last message = %v, want a user turn after the assistant's", last)
+			}
+		}},
+		{ProviderOllama, "ollama.ndjson", "/api/chat", "", "", func(t *testing.T, body map[string]any) {
+			if body["stream"] != true || len(body["messages"].([]any)) != 3 {
+				t.Errorf("unexpected body: %v", body)
+			}
+		}},
+		{ProviderGemini, "gemini.sse", "/models/test-model:streamGenerateContent", "X-Goog-Api-Key", "", func(t *testing.T, body map[string]any) {
+			if body["systemInstruction"] == nil {
+				t.Error("system instruction not sent")
+			}
+			contents := body["contents"].([]any)
+			if prev := contents[len(contents)-2].(map[string]any); prev["role"] != "model" {
+				t.Errorf("assistant turn sent with role %v, want model", prev["role"])
+			}
+			if last := contents[len(contents)-1].(map[string]any); last["role"] != "user" {
+				t.Errorf("last turn has role %v, want user", last["role"])
+			}
+		}},
+	}
+
+	messages := []ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Topic?", Name: "Mod"},
+		{Role: "assistant", Content: "Sure."},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.provider, func(t *testing.T) {
+			fixture, err := os.ReadFile("testdata/" + tc.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body map[string]any
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != tc.path {
+					t.Errorf("path = %s, want %s", r.URL.Path, tc.path)
+				}
+				if tc.header != "" && r.Header.Get(tc.header) != tc.prefix+"key" {
+					t.Errorf("%s = %q", tc.header, r.Header.Get(tc.header))
+				}
+				data, _ := io.ReadAll(r.Body)
+				if err := json.Unmarshal(data, &body); err != nil {
+					t.Errorf("request body: %v", err)
+				}
+				w.Write(fixture)
+			}))
+			defer srv.Close()
+
+			p, err := New(Config{Provider: tc.provider, BaseURL: srv.URL, APIKey: "key", Model: "test-model"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply, err := p.Chat(context.Background(), messages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply != "Hello, world" {
+				t.Errorf("reply = %q, want %q", reply, "Hello, world")
+			}
+			tc.check(t, body)
+		})
+	}
+}
+
+func TestProviderAPIError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, `{"error":"bad key"}`, http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	for _, name := range []string{ProviderOpenAI, ProviderAnthropic, ProviderOllama, ProviderGemini} {
+		p, _ := New(Config{Provider: name, BaseURL: srv.URL})
+		if _, err := p.ChatStream(context.Background(), nil); err == nil {
+			t.Errorf("%s: expected an error for a 401", name)
+		}
+	}
+	if _, err := New(Config{Provider: "nope"}); err == nil {
+		t.Error("expected an error for an unknown provider")
+	}
+}
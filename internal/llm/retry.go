@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy says how often and how patiently failed requests are retried.
+type RetryPolicy struct {
+	MaxRetries int           // Retries after the first attempt
+	BaseDelay  time.Duration // Delay before the first retry; doubles each time
+	MaxDelay   time.Duration // Cap on the doubled delay
+}
+
+// DefaultRetry is used where no policy is configured.
+var DefaultRetry = RetryPolicy{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 30 * time.Second}
+
+// Delay is how long to wait before retry number attempt (from 0) after
+// err. A Retry-After from the server wins; otherwise the delay doubles
+// each attempt, capped at MaxDelay, with up to half of it taken off at
+// random so clients that failed together don't retry together.
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	d := p.BaseDelay << min(attempt, 30)
+	if d <= 0 || (p.MaxDelay > 0 && d > p.MaxDelay) {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// fallback is a Provider that tries the models of a profile in order. A
+// request moves on to the next model when one is rate limited, failing or
+// can't take the prompt; other errors, such as a bad key, are returned
+// as they are. Prompts are sized for the first model.
+type fallback struct {
+	Provider
+	next []Provider
+}
+
+// ChatStream streams from the first model that accepts the request and
+// returns the last error if none does.
+func (f *fallback) ChatStream(ctx context.Context, messages []ChatMessage) (*Stream, error) {
+	stream, err := f.Provider.ChatStream(ctx, messages)
+	for _, p := range f.next {
+		if err == nil || !(Retryable(err) || Classify(err) == KindContextLength) || ctx.Err() != nil {
+			break
+		}
+		stream, err = p.ChatStream(ctx, messages)
+	}
+	return stream, err
+}
+
+// Chat collects the whole streamed reply.
+func (f *fallback) Chat(ctx context.Context, messages []ChatMessage) (string, error) {
+	return collect(ctx, f, messages)
+}
@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPIErrorKinds(t *testing.T) {
+	cases := []struct {
+		status int
+		body   string
+		want   ErrorKind
+	}{
+		{http.StatusTooManyRequests, "", KindRateLimit},
+		{http.StatusUnauthorized, "", KindAuth},
+		{http.StatusBadRequest, `{"error":{"code":"context_length_exceeded"}}`, KindContextLength},
+		{http.StatusBadRequest, `{"error":{"message":"prompt is too long: 210000 tokens"}}`, KindContextLength},
+		{http.StatusBadRequest, "bad json", KindRequest},
+		{529, "overloaded", KindServer},
+	}
+	for _, tc := range cases {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(tc.status)
+			io.WriteString(w, tc.body)
+		}))
+		_, err := NewClient(Config{BaseURL: srv.URL}).ChatStream(context.Background(), nil)
+		srv.Close()
+
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.Kind != tc.want {
+			t.Errorf("%d %q: err = %v, want kind %s", tc.status, tc.body, err, tc.want)
+			continue
+		}
+		if apiErr.RetryAfter != 7*time.Second {
+			t.Errorf("RetryAfter = %s, want 7s", apiErr.RetryAfter)
+		}
+	}
+
+	// Nothing listening: a network error, which is worth retrying.
+	_, err := NewClient(Config{BaseURL: "http://127.0.0.1:1"}).ChatStream(context.Background(), nil)
+	if Classify(err) != KindNetwork || !Retryable(err) {
+		t.Errorf("err = %v, want a retryable network error", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{MaxRetries: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		if d := p.Delay(attempt, errors.New("x")); d < want/2 || d > want {
+			t.Errorf("attempt %d: delay %s, want within [%s, %s]", attempt, d, want/2, want)
+		}
+	}
+	limited := &APIError{Kind: KindRateLimit, RetryAfter: 3 * time.Second}
+	if d := p.Delay(0, fmt.Errorf("wrapped: %w", limited)); d != 3*time.Second {
+		t.Errorf("delay = %s, want the server's Retry-After", d)
+	}
+}
+
+func TestFallbackModels(t *testing.T) {
+	var tried []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case strings.Contains(string(body), `"model":"big"`):
+			tried = append(tried, "big")
+			http.Error(w, "busy", http.StatusTooManyRequests)
+		case strings.Contains(string(body), `"model":"bad-key"`):
+			tried = append(tried, "bad-key")
+			http.Error(w, "no", http.StatusUnauthorized)
+		default:
+			tried = append(tried, "small")
+			io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\ndata: [DONE]\n\n")
+		}
+	}))
+	defer srv.Close()
+
+	p, err := New(Config{BaseURL: srv.URL, Model: "big", Fallbacks: []string{"small"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply, err := p.Chat(context.Background(), nil); err != nil || reply != "ok" {
+		t.Fatalf("reply = %q, %v", reply, err)
+	}
+	if strings.Join(tried, ",") != "big,small" {
+		t.Errorf("tried %v, want big then small", tried)
+	}
+
+	// An auth error isn't one another model can fix.
+	tried = nil
+	p, _ = New(Config{BaseURL: srv.URL, Model: "bad-key", Fallbacks: []string{"small"}})
+	if _, err := p.Chat(context.Background(), nil); Classify(err) != KindAuth {
+		t.Errorf("err = %v, want an auth error", err)
+	}
+	if len(tried) != 1 {
+		t.Errorf("tried %v, want no fallback", tried)
+	}
+}
@@ -0,0 +1,119 @@
+package llm
+
+import "fmt"
+
+// Sampling holds generation settings. Nil fields are left to the provider's
+// defaults.
+type Sampling struct {
+	Temperature      *float64 `json:"temperature,omitempty"`
+	TopP             *float64 `json:"topP,omitempty"`
+	MaxTokens        *int     `json:"maxTokens,omitempty"`
+	PresencePenalty  *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequencyPenalty,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+}
+
+// maxStop is the most stop sequences any provider accepts (OpenAI's limit).
+const maxStop = 4
+
+// Merge returns s with every setting over has overriding it.
+func (s Sampling) Merge(over Sampling) Sampling {
+	if over.Temperature != nil {
+		s.Temperature = over.Temperature
+	}
+	if over.TopP != nil {
+		s.TopP = over.TopP
+	}
+	if over.MaxTokens != nil {
+		s.MaxTokens = over.MaxTokens
+	}
+	if over.PresencePenalty != nil {
+		s.PresencePenalty = over.PresencePenalty
+	}
+	if over.FrequencyPenalty != nil {
+		s.FrequencyPenalty = over.FrequencyPenalty
+	}
+	if over.Stop != nil {
+		s.Stop = over.Stop
+	}
+	if over.Seed != nil {
+		s.Seed = over.Seed
+	}
+	return s
+}
+
+// IsZero reports whether no setting is made.
+func (s Sampling) IsZero() bool {
+	return s.Temperature == nil && s.TopP == nil && s.MaxTokens == nil &&
+		s.PresencePenalty == nil && s.FrequencyPenalty == nil && s.Stop == nil && s.Seed == nil
+}
+
+// Validate checks the settings are in range for provider and that the
+// provider supports them.
+func (s Sampling) Validate(provider string) error {
+	maxTemp := maxTemperature(provider)
+	if t := s.Temperature; t != nil && (*t < 0 || *t > maxTemp) {
+		return fmt.Errorf("temperature must be between 0 and %g", maxTemp)
+	}
+	if p := s.TopP; p != nil && (*p < 0 || *p > 1) {
+		return fmt.Errorf("topP must be between 0 and 1")
+	}
+	if m := s.MaxTokens; m != nil && *m <= 0 {
+		return fmt.Errorf("maxTokens must be positive")
+	}
+	if p := s.PresencePenalty; p != nil && (*p < -2 || *p > 2) {
+		return fmt.Errorf("presencePenalty must be between -2 and 2")
+	}
+	if p := s.FrequencyPenalty; p != nil && (*p < -2 || *p > 2) {
+		return fmt.Errorf("frequencyPenalty must be between -2 and 2")
+	}
+	if len(s.Stop) > maxStop {
+		return fmt.Errorf("at most %d stop sequences are allowed", maxStop)
+	}
+	if provider == ProviderAnthropic {
+		switch {
+		case s.PresencePenalty != nil, s.FrequencyPenalty != nil:
+			return fmt.Errorf("anthropic does not support presence or frequency penalties")
+		case s.Seed != nil:
+			return fmt.Errorf("anthropic does not support seed")
+		}
+	}
+	return nil
+}
+
+// Clamp returns s adjusted to what provider accepts: values are brought into
+// range and settings the provider lacks are dropped. Settings are chosen
+// per role and participant without knowing the provider, which may only be
+// known at connect time; Clamp lets such a conversation still start.
+func (s Sampling) Clamp(provider string) Sampling {
+	clamp := func(v *float64, lo, hi float64) *float64 {
+		if v == nil {
+			return nil
+		}
+		c := min(max(*v, lo), hi)
+		return &c
+	}
+	s.Temperature = clamp(s.Temperature, 0, maxTemperature(provider))
+	s.TopP = clamp(s.TopP, 0, 1)
+	s.PresencePenalty = clamp(s.PresencePenalty, -2, 2)
+	s.FrequencyPenalty = clamp(s.FrequencyPenalty, -2, 2)
+	if s.MaxTokens != nil && *s.MaxTokens <= 0 {
+		s.MaxTokens = nil
+	}
+	if len(s.Stop) > maxStop {
+		s.Stop = s.Stop[:maxStop]
+	}
+	if provider == ProviderAnthropic {
+		s.PresencePenalty, s.FrequencyPenalty, s.Seed = nil, nil, nil
+	}
+	return s
+}
+
+// maxTemperature is the highest temperature provider accepts.
+func maxTemperature(provider string) float64 {
+	if provider == ProviderAnthropic {
+		return 1
+	}
+	return 2
+}
@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestSamplingMerge(t *testing.T) {
+	role := Sampling{Temperature: ptr(1.3), TopP: ptr(0.9)}
+	got := role.Merge(Sampling{Temperature: ptr(0.0), Seed: ptr(int64(7))})
+	if *got.Temperature != 0 || *got.TopP != 0.9 || *got.Seed != 7 {
+		t.Errorf("merged = %+v", got)
+	}
+	if !(Sampling{}).IsZero() || got.IsZero() {
+		t.Error("IsZero is wrong")
+	}
+}
+
+func TestSamplingValidate(t *testing.T) {
+	cases := []struct {
+		provider string
+		s        Sampling
+		ok       bool
+	}{
+		{ProviderOpenAI, Sampling{Temperature: ptr(1.5), Seed: ptr(int64(1))}, true},
+		{ProviderAnthropic, Sampling{Temperature: ptr(1.5)}, false},
+		{ProviderAnthropic, Sampling{Seed: ptr(int64(1))}, false},
+		{ProviderAnthropic, Sampling{FrequencyPenalty: ptr(0.5)}, false},
+		{ProviderGemini, Sampling{TopP: ptr(1.1)}, false},
+		{ProviderOllama, Sampling{MaxTokens: ptr(0)}, false},
+		{ProviderOpenAI, Sampling{Stop: []string{"a", "b", "c", "d", "e"}}, false},
+	}
+	for i, tc := range cases {
+		if err := tc.s.Validate(tc.provider); (err == nil) != tc.ok {
+			t.Errorf("case %d: err = %v, want ok = %v", i, err, tc.ok)
+		}
+	}
+	if _, err := New(Config{Provider: ProviderAnthropic, Sampling: Sampling{Seed: ptr(int64(1))}}); err == nil {
+		t.Error("New accepted sampling the provider doesn't support")
+	}
+}
+
+// Each adapter sends the settings under its own API's names.
+func TestSamplingSent(t *testing.T) {
+	s := Sampling{Temperature: ptr(0.0), TopP: ptr(0.5), MaxTokens: ptr(64), Stop: []string{"END"}}
+	cases := []struct {
+		provider string
+		fixture  string
+		get      func(body map[string]any) map[string]any
+		keys     []string
+	}{
+		{ProviderOpenAI, "openai.sse", func(b map[string]any) map[string]any { return b }, []string{"temperature", "top_p", "max_tokens", "stop"}},
+		{ProviderAnthropic, "anthropic.sse", func(b map[string]any) map[string]any { return b }, []string{"temperature", "top_p", "max_tokens", "stop_sequences"}},
+		{ProviderOllama, "ollama.ndjson", func(b map[string]any) map[string]any { return b["options"].(map[string]any) }, []string{"temperature", "top_p", "num_predict", "stop"}},
+		{ProviderGemini, "gemini.sse", func(b map[string]any) map[string]any { return b["generationConfig"].(map[string]any) }, []string{"temperature", "topP", "maxOutputTokens", "stopSequences"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.provider, func(t *testing.T) {
+			fixture, err := os.ReadFile("testdata/" + tc.fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body map[string]any
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, _ := io.ReadAll(r.Body)
+				json.Unmarshal(data, &body)
+				w.Write(fixture)
+			}))
+			defer srv.Close()
+
+			p, err := New(Config{Provider: tc.provider, BaseURL: srv.URL, Model: "m", Sampling: s})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := p.Chat(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}}); err != nil {
+				t.Fatal(err)
+			}
+			got := tc.get(body)
+			for _, k := range tc.keys {
+				if _, ok := got[k]; !ok {
+					t.Errorf("%s not sent: %v", k, got)
+				}
+			}
+			if got[tc.keys[2]] != float64(64) {
+				t.Errorf("%s = %v, want 64", tc.keys[2], got[tc.keys[2]])
+			}
+		})
+	}
+}
+
+func TestSamplingClamp(t *testing.T) {
+	s := Sampling{Temperature: ptr(1.3), Seed: ptr(int64(1)), Stop: []string{"a", "b", "c", "d", "e"}}
+	for _, provider := range []string{ProviderOpenAI, ProviderAnthropic, ProviderOllama, ProviderGemini} {
+		if err := s.Clamp(provider).Validate(provider); err != nil {
+			t.Errorf("%s: clamped settings invalid: %v", provider, err)
+		}
+	}
+	if c := s.Clamp(ProviderAnthropic); *c.Temperature != 1 || c.Seed != nil {
+		t.Errorf("anthropic clamp = %+v", c)
+	}
+	if c := s.Clamp(ProviderOpenAI); *c.Temperature != 1.3 || *s.Temperature != 1.3 {
+		t.Error("clamp changed an in-range value or its input")
+	}
+}
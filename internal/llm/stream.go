@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stream is a streamed reply. C carries the text and is closed when the
+// reply ends; Result then says how it ended.
+type Stream struct {
+	C <-chan string
+
+	done   chan struct{}
+	result Result
+}
+
+// Result waits for the stream to end and returns how it ended.
+func (s *Stream) Result() Result {
+	<-s.done
+	return s.result
+}
+
+// Result is how a streamed reply ended.
+type Result struct {
+	// FinishReason is why the model stopped, normalized across providers:
+	// FinishStop, FinishLength, or the provider's own reason in lower case
+	// (e.g. "content_filter"). Empty if the stream never said.
+	FinishReason string
+	// Usage is the token count the provider reported, if it did.
+	Usage *TokenUsage
+	// Err is what cut the stream short: a read error, an undecodable
+	// chunk, an error sent inside the stream, ErrIdleTimeout or
+	// ErrTruncated. Nil if the stream ended as the provider meant it to.
+	Err error
+}
+
+// TokenUsage is a provider's count of the tokens a request used.
+type TokenUsage struct {
+	PromptTokens     int `json:"promptTokens"`
+	CompletionTokens int `json:"completionTokens"`
+}
+
+// Normalized finish reasons.
+const (
+	FinishStop   = "stop"   // The model finished its answer
+	FinishLength = "length" // The answer hit the token limit
+)
+
+var (
+	// ErrIdleTimeout ends a stream that sent nothing for Config.IdleTimeout.
+	ErrIdleTimeout = errors.New("stream stalled")
+	// ErrTruncated ends a stream whose connection closed before the
+	// provider said the reply was finished.
+	ErrTruncated = errors.New("stream ended before the reply was finished")
+	// ErrLength is what Incomplete reports for a reply cut off at the token limit.
+	ErrLength = errors.New("reply cut off at the token limit")
+)
+
+// DefaultIdleTimeout is used when Config.IdleTimeout is 0.
+const DefaultIdleTimeout = 60 * time.Second
+
+// Incomplete returns why the reply is incomplete, or nil if the model
+// finished it.
+func (r Result) Incomplete() error {
+	if r.Err != nil {
+		return r.Err
+	}
+	if r.FinishReason == FinishLength {
+		return ErrLength
+	}
+	return nil
+}
+
+// finishReason maps a provider's stop reason onto the normalized ones.
+func finishReason(raw string) string {
+	switch reason := strings.ToLower(raw); reason {
+	case "stop", "end_turn", "stop_sequence":
+		return FinishStop
+	case "length", "max_tokens":
+		return FinishLength
+	default:
+		return reason
+	}
+}
+
+// streamError is an error the provider sent inside the stream.
+func streamError(kind, message string) *APIError {
+	e := &APIError{Kind: KindRequest, Err: fmt.Errorf("%s: %s", kind, message)}
+	switch k := strings.ToLower(kind); {
+	case strings.Contains(k, "rate") || strings.Contains(k, "exhausted"):
+		e.Kind = KindRateLimit
+	case strings.Contains(k, "overloaded") || strings.Contains(k, "server") ||
+		strings.Contains(k, "internal") || strings.Contains(k, "unavailable"):
+		e.Kind = KindServer
+	case strings.Contains(k, "auth") || strings.Contains(k, "permission"):
+		e.Kind = KindAuth
+	case contextLengthError(message):
+		e.Kind = KindContextLength
+	}
+	return e
+}
+
+// streamLines reads body line by line and sends the text parse finds in
+// each non-empty line, until parse reports the end or the body ends. parse
+// records the finish reason and usage in res as they arrive; setting
+// res.Err ends the stream. If nothing arrives for idle, the body is closed
+// and the stream ends with ErrIdleTimeout.
+func streamLines(ctx context.Context, body io.ReadCloser, idle time.Duration, parse func(line string, res *Result) (text string, done bool)) *Stream {
+	out := make(chan string)
+	s := &Stream{C: out, done: make(chan struct{})}
+	if idle <= 0 {
+		idle = DefaultIdleTimeout
+	}
+
+	go func() {
+		defer body.Close()
+		defer close(out)
+		defer close(s.done)
+
+		var stalled atomic.Bool
+		watchdog := time.AfterFunc(idle, func() {
+			stalled.Store(true)
+			body.Close() // Unblocks the read below
+		})
+		defer watchdog.Stop()
+
+		res := &s.result
+		reader := bufio.NewReader(body)
+		for {
+			line, err := reader.ReadString('\n')
+			line = strings.TrimSpace(line)
+			if line != "" {
+				text, done := parse(line, res)
+				if text != "" {
+					// A slow reader isn't a stalled stream.
+					watchdog.Stop()
+					select {
+					case out <- text:
+					case <-ctx.Done():
+						// Reader went away; closing the body aborts the request.
+						res.Err = ctx.Err()
+						return
+					}
+				}
+				if done || res.Err != nil {
+					return
+				}
+			}
+			if err != nil {
+				switch {
+				case ctx.Err() != nil:
+					// The body read fails as soon as the transport
+					// tears the connection down.
+					res.Err = ctx.Err()
+				case stalled.Load():
+					res.Err = &APIError{Kind: KindNetwork, Err: fmt.Errorf("%w: nothing received for %s", ErrIdleTimeout, idle)}
+				case err != io.EOF:
+					res.Err = &APIError{Kind: KindNetwork, Err: err}
+				case res.FinishReason == "":
+					res.Err = ErrTruncated
+				}
+				return
+			}
+			watchdog.Reset(idle)
+		}
+	}()
+
+	return s
+}
+
+// decodeError records a chunk that isn't the JSON the provider promised.
+func decodeError(res *Result, err error) {
+	res.Err = fmt.Errorf("decoding stream: %w", err)
+}
@@ -0,0 +1,118 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// drain reads a stream to the end and returns its text and result.
+func drain(t *testing.T, p Provider) (string, Result) {
+	t.Helper()
+	stream, err := p.ChatStream(context.Background(), []ChatMessage{{Role: "user", Content: "hi"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text string
+	for chunk := range stream.C {
+		text += chunk
+	}
+	return text, stream.Result()
+}
+
+func TestStreamResultFromFixtures(t *testing.T) {
+	for provider, fixture := range map[string]string{
+		ProviderOpenAI:    "openai.sse",
+		ProviderAnthropic: "anthropic.sse",
+		ProviderOllama:    "ollama.ndjson",
+		ProviderGemini:    "gemini.sse",
+	} {
+		t.Run(provider, func(t *testing.T) {
+			data, err := os.ReadFile("testdata/" + fixture)
+			if err != nil {
+				t.Fatal(err)
+			}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(data)
+			}))
+			defer srv.Close()
+
+			p, _ := New(Config{Provider: provider, BaseURL: srv.URL, Model: "m"})
+			_, res := drain(t, p)
+			if res.Err != nil || res.FinishReason != FinishStop {
+				t.Errorf("result = %+v, want a clean stop", res)
+			}
+			if res.Usage == nil || res.Usage.PromptTokens == 0 || res.Usage.CompletionTokens != 5 {
+				t.Errorf("usage = %+v, want the reported counts", res.Usage)
+			}
+		})
+	}
+}
+
+func TestStreamCutShort(t *testing.T) {
+	cases := []struct {
+		name     string
+		provider string
+		body     string
+		check    func(res Result) bool
+	}{
+		{"no end marker", ProviderOpenAI,
+			"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n",
+			func(res Result) bool { return errors.Is(res.Err, ErrTruncated) }},
+		{"error object", ProviderOpenAI,
+			"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\ndata: {\"error\":{\"type\":\"server_error\",\"message\":\"boom\"}}\n\n",
+			func(res Result) bool { return Classify(res.Err) == KindServer }},
+		{"undecodable chunk", ProviderOpenAI,
+			"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\ndata: {oops\n\n",
+			func(res Result) bool { return res.Err != nil && Classify(res.Err) == "" }},
+		{"token limit", ProviderOpenAI,
+			"data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"},\"finish_reason\":\"length\"}]}\n\ndata: [DONE]\n\n",
+			func(res Result) bool { return res.Err == nil && errors.Is(res.Incomplete(), ErrLength) }},
+		{"anthropic overloaded", ProviderAnthropic,
+			"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\nevent: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n",
+			func(res Result) bool { return Classify(res.Err) == KindServer }},
+		{"anthropic max tokens", ProviderAnthropic,
+			"data: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"max_tokens\"},\"usage\":{\"output_tokens\":3}}\n\ndata: {\"type\":\"message_stop\"}\n\n",
+			func(res Result) bool { return res.FinishReason == FinishLength }},
+		{"ollama error", ProviderOllama,
+			"{\"message\":{\"content\":\"Hel\"},\"done\":false}\n{\"error\":\"model runner crashed\"}\n",
+			func(res Result) bool { return res.Err != nil }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, tc.body)
+			}))
+			defer srv.Close()
+
+			p, _ := New(Config{Provider: tc.provider, BaseURL: srv.URL, Model: "m"})
+			if _, res := drain(t, p); !tc.check(res) || res.Incomplete() == nil {
+				t.Errorf("result = %+v", res)
+			}
+		})
+	}
+}
+
+func TestStreamIdleTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Hel\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done() // Then nothing
+	}))
+	defer srv.Close()
+
+	p := NewClient(Config{BaseURL: srv.URL, IdleTimeout: 50 * time.Millisecond})
+	start := time.Now()
+	text, res := drain(t, p)
+	if text != "Hel" || !errors.Is(res.Err, ErrIdleTimeout) {
+		t.Errorf("text = %q, result = %+v, want the partial text and a stall", text, res)
+	}
+	if time.Since(start) > 2*time.Second {
+		t.Error("watchdog fired late")
+	}
+}
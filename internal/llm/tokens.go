@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Tokenizer counts the tokens a model would see for a piece of text.
+// Exact counts need the model's own vocabulary; anything close is good
+// enough, since the window keeps a reserve for the reply.
+type Tokenizer interface {
+	CountTokens(text string) int
+}
+
+// TokenizerFunc adapts a plain function to Tokenizer.
+type TokenizerFunc func(text string) int
+
+func (f TokenizerFunc) CountTokens(text string) int { return f(text) }
+
+// Estimator is the offline Tokenizer used when none is configured.
+var Estimator Tokenizer = TokenizerFunc(EstimateTokens)
+
+// EstimateTokens is a rough offline token count: about four ASCII
+// characters per token, and one token per non-ASCII rune (CJK text).
+func EstimateTokens(s string) int {
+	ascii, other := 0, 0
+	for _, r := range s {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return (ascii+3)/4 + other
+}
+
+// messageOverhead approximates the per-message framing (role, separators).
+const messageOverhead = 4
+
+// CountMessages estimates the prompt size of messages.
+func CountMessages(tok Tokenizer, messages []ChatMessage) int {
+	n := 0
+	for _, m := range messages {
+		n += messageOverhead + tok.CountTokens(m.Content) + tok.CountTokens(m.Name)
+	}
+	return n
+}
+
+// DefaultContextWindow is assumed for models missing from contextWindows.
+const DefaultContextWindow = 8192
+
+// DefaultReplyReserve is the part of the window kept free for the reply.
+const DefaultReplyReserve = 1024
+
+// contextWindows maps model name prefixes to context sizes in tokens.
+// The longest matching prefix wins, so "gpt-4o" beats "gpt-4".
+var contextWindows = map[string]int{
+	"gpt-3.5-turbo":    16385,
+	"gpt-4":            8192,
+	"gpt-4-32k":        32768,
+	"gpt-4-turbo":      128000,
+	"gpt-4o":           128000,
+	"gpt-4.1":          1047576,
+	"o1":               200000,
+	"o3":               200000,
+	"o4":               200000,
+	"claude":           200000,
+	"gemini":           1048576,
+	"deepseek":         65536,
+	"qwen":             32768,
+	"moonshot-v1-8k":   8192,
+	"moonshot-v1-32k":  32768,
+	"moonshot-v1-128k": 131072,
+	"llama3":           8192,
+	"llama3.1":         131072,
+}
+
+// ContextWindow returns the context size of model, in tokens.
+func ContextWindow(model string) int {
+	model = strings.ToLower(model)
+	// Strip a provider prefix such as "openai/gpt-4o".
+	if i := strings.LastIndex(model, "/"); i >= 0 {
+		model = model[i+1:]
+	}
+
+	best, size := "", DefaultContextWindow
+	for prefix, n := range contextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > len(best) {
+			best, size = prefix, n
+		}
+	}
+	return size
+}
+
+// FitTurns trims a transcript to budget tokens. Pinned turns are always
+// kept; the rest are taken newest first until the next one would not fit,
+// so the kept history is the most recent contiguous stretch. Order is preserved.
+func FitTurns(turns []Turn, budget int, tok Tokenizer) []Turn {
+	cost := func(t Turn) int {
+		// Counted as if name-prefixed, the largest of the NameStyles.
+		return messageOverhead + tok.CountTokens(t.Speaker) + tok.CountTokens(t.Content) + 1
+	}
+
+	keep := make([]bool, len(turns))
+	used := 0
+	for i, t := range turns {
+		if t.Pinned {
+			keep[i] = true
+			used += cost(t)
+		}
+	}
+	for i := len(turns) - 1; i >= 0; i-- {
+		if keep[i] {
+			continue
+		}
+		c := cost(turns[i])
+		if used+c > budget {
+			break
+		}
+		keep[i] = true
+		used += c
+	}
+
+	fitted := make([]Turn, 0, len(turns))
+	for i, t := range turns {
+		if keep[i] {
+			fitted = append(fitted, t)
+		}
+	}
+	return fitted
+}
@@ -0,0 +1,58 @@
+package llm
+
+import "testing"
+
+func TestEstimateTokens(t *testing.T) {
+	if got := EstimateTokens("abcdefgh"); got != 2 {
+		t.Fatalf("EstimateTokens(ascii) = %d, want 2", got)
+	}
+	if got := EstimateTokens("你好"); got != 2 {
+		t.Fatalf("EstimateTokens(cjk) = %d, want 2", got)
+	}
+}
+
+func TestContextWindow(t *testing.T) {
+	cases := map[string]int{
+		"gpt-4o-mini":        128000,
+		"gpt-4":              8192,
+		"openai/gpt-4-turbo": 128000,
+		"some-local-model":   DefaultContextWindow,
+	}
+	for model, want := range cases {
+		if got := ContextWindow(model); got != want {
+			t.Errorf("ContextWindow(%q) = %d, want %d", model, got, want)
+		}
+	}
+}
+
+func TestFitTurnsKeepsPinnedAndNewest(t *testing.T) {
+	// Each turn costs 4 (overhead) + 1 (speaker) + 1 (content) + 1 = 7 tokens.
+	turns := []Turn{
+		{Speaker: "M", Content: "t", Pinned: true},
+		{Speaker: "A", Content: "1"},
+		{Speaker: "B", Content: "2"},
+		{Speaker: "A", Content: "3"},
+		{Speaker: "B", Content: "4"},
+	}
+	got := FitTurns(turns, 21, Estimator)
+
+	want := []string{"t", "3", "4"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d turns, want %d: %+v", len(got), len(want), got)
+	}
+	for i, w := range want {
+		if got[i].Content != w {
+			t.Fatalf("turn %d = %q, want %q", i, got[i].Content, w)
+		}
+	}
+}
+
+func TestFitTurnsCustomTokenizer(t *testing.T) {
+	words := TokenizerFunc(func(s string) int { return len(s) })
+	turns := []Turn{{Speaker: "A", Content: "long message"}, {Speaker: "B", Content: "hi"}}
+
+	got := FitTurns(turns, 10, words)
+	if len(got) != 1 || got[0].Content != "hi" {
+		t.Fatalf("got %+v, want only the newest turn", got)
+	}
+}
@@ -0,0 +1,211 @@
+// Package scenario loads reusable conversation setups from YAML or JSON files.
+package scenario
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"qigent/internal/chat"
+	"qigent/internal/data"
+	"qigent/internal/llm"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+// Scenario is a declarative conversation setup. Field names are the same in
+// YAML and JSON files (see the files under scenarios/ for examples).
+type Scenario struct {
+	// Name identifies the scenario; it defaults to the file name.
+	Name string `json:"name"`
+	// Version is bumped by hand whenever the setup changes meaningfully.
+	// Conversations record the version they were started from.
+	Version     int    `json:"version"`
+	Description string `json:"description,omitempty"`
+	// Topic is the default topic; starting a conversation may override it.
+	Topic string `json:"topic,omitempty"`
+
+	// Participants take a role-market name (role) or an inline prompt.
+	Participants []data.AgentConfig `json:"participants"`
+	Moderator    *data.AgentConfig  `json:"moderator,omitempty"`
+	TurnPolicy   string             `json:"turnPolicy,omitempty"`
+	// Format holds the debate phases, or names a built-in format.
+	Format      *chat.Format     `json:"format,omitempty"`
+	Judge       chat.JudgeConfig `json:"judge,omitempty"`
+	StopRules   chat.StopRules   `json:"stopRules,omitempty"`
+	Model       ModelSettings    `json:"model,omitempty"`
+	OnLastLeave string           `json:"onLastLeave,omitempty"`
+
+	// File and Digest are filled in by the loader: the file the scenario
+	// came from, and a hash of its contents to spot unversioned edits.
+	File   string `json:"file" yaml:"-"`
+	Digest string `json:"digest" yaml:"-"`
+}
+
+// ModelSettings are the model-related conversation settings.
+type ModelSettings struct {
+	Name         string        `json:"name,omitempty"`
+	NameStyle    llm.NameStyle `json:"nameStyle,omitempty"`
+	SummaryEvery int           `json:"summaryEvery,omitempty"`
+	Scratchpad   bool          `json:"scratchpad,omitempty"`
+}
+
+// FileError reports a scenario file that could not be loaded.
+type FileError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// Parse decodes and validates one scenario. YAML and JSON are both accepted
+// (JSON is valid YAML); unknown fields are rejected to catch typos.
+func Parse(file string, content []byte) (*Scenario, error) {
+	var s Scenario
+	if err := yaml.UnmarshalWithOptions(content, &s, yaml.Strict()); err != nil {
+		return nil, err
+	}
+	if s.Name == "" {
+		s.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	sum := sha256.Sum256(content)
+	s.File = file
+	s.Digest = hex.EncodeToString(sum[:])[:12]
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// Validate checks the scenario without touching the database; role
+// references are only resolved when a conversation is started.
+func (s *Scenario) Validate() error {
+	if !validName.MatchString(s.Name) {
+		return fmt.Errorf("invalid scenario name %q (use lowercase letters, digits, - and _)", s.Name)
+	}
+	if s.Version < 1 {
+		return fmt.Errorf("version must be 1 or higher")
+	}
+	for _, p := range s.Participants {
+		if p.Prompt == "" && p.Role == "" {
+			return fmt.Errorf("participant %s needs a role or a prompt", p.Name)
+		}
+	}
+	if s.Format != nil {
+		if err := s.Format.Resolve(); err != nil {
+			return err
+		}
+	}
+	return s.Conversation(0, s.Topic).Validate()
+}
+
+// Conversation builds a new, unsaved conversation from the scenario.
+// A non-empty topic overrides the scenario's own.
+func (s *Scenario) Conversation(userID uint, topic string) *data.Conversation {
+	if topic == "" {
+		topic = s.Topic
+	}
+
+	// Copy everything that Resolve may fill in, so the scenario stays as loaded.
+	agents := append([]data.AgentConfig(nil), s.Participants...)
+	var moderator *data.AgentConfig
+	if s.Moderator != nil {
+		m := *s.Moderator
+		moderator = &m
+	}
+	var format *chat.Format
+	if s.Format != nil {
+		f := *s.Format
+		format = &f
+	}
+	judge := s.Judge
+	judge.Panel = append([]chat.JudgeConfig(nil), s.Judge.Panel...)
+
+	return &data.Conversation{
+		ID:              chat.NewID(),
+		UserID:          userID,
+		Topic:           topic,
+		Status:          "active",
+		Agents:          agents,
+		History:         []chat.Message{},
+		TurnPolicy:      s.TurnPolicy,
+		Moderator:       moderator,
+		StopRules:       s.StopRules,
+		OnLastLeave:     s.OnLastLeave,
+		Model:           s.Model.Name,
+		NameStyle:       s.Model.NameStyle,
+		SummaryEvery:    s.Model.SummaryEvery,
+		Scratchpad:      s.Model.Scratchpad,
+		Judge:           judge,
+		Format:          format,
+		Scenario:        s.Name,
+		ScenarioVersion: s.Version,
+		CreatedAt:       chat.Now(),
+	}
+}
+
+// LoadDir loads every *.yaml, *.yml and *.json file in dir, sorted by name.
+// Files that fail to parse or validate, and duplicate names, are reported
+// as FileErrors rather than failing the whole load. A missing dir is empty.
+func LoadDir(dir string) ([]*Scenario, []FileError, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var scenarios []*Scenario
+	var problems []FileError
+	seen := make(map[string]string)
+	for _, e := range entries {
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		if e.IsDir() {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			problems = append(problems, FileError{File: e.Name(), Error: err.Error()})
+			continue
+		}
+		s, err := Parse(e.Name(), content)
+		if err != nil {
+			problems = append(problems, FileError{File: e.Name(), Error: err.Error()})
+			continue
+		}
+		if other, dup := seen[s.Name]; dup {
+			problems = append(problems, FileError{File: e.Name(), Error: fmt.Sprintf("scenario %s is already defined in %s", s.Name, other)})
+			continue
+		}
+		seen[s.Name] = e.Name()
+		scenarios = append(scenarios, s)
+	}
+
+	sort.Slice(scenarios, func(i, j int) bool { return scenarios[i].Name < scenarios[j].Name })
+	return scenarios, problems, nil
+}
+
+// Find loads dir and returns the named scenario, or nil if there is none.
+func Find(dir, name string) (*Scenario, error) {
+	scenarios, _, err := LoadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range scenarios {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,88 @@
+package scenario
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"debate.yaml": `
+version: 2
+topic: Tabs or spaces?
+participants:
+  - {name: A, prompt: Argue for tabs.}
+  - {name: B, role: Critic}
+format: {name: lightning}
+judge:
+  aggregate: median
+  panel: [{name: J1}, {name: J2, model: other-model}]
+`,
+		"chat.json":      `{"name": "chat", "version": 1, "participants": [{"name": "A", "prompt": "p"}]}`,
+		"dup.yml":        "name: chat\nversion: 1\nparticipants: [{name: A, prompt: p}]\n",
+		"typo.yaml":      "version: 1\nparticipant: []\n",
+		"noversion.json": `{"participants": [{"name": "A", "prompt": "p"}]}`,
+		"readme.txt":     "ignored",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scenarios, problems, err := LoadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenarios) != 2 || scenarios[0].Name != "chat" || scenarios[1].Name != "debate" {
+		t.Fatalf("scenarios = %+v", scenarios)
+	}
+	if len(problems) != 3 {
+		t.Fatalf("problems = %+v, want dup, typo and noversion", problems)
+	}
+
+	debate := scenarios[1]
+	if debate.Version != 2 || len(debate.Format.Phases) == 0 || debate.Digest == "" {
+		t.Fatalf("debate = %+v", debate)
+	}
+
+	conv := debate.Conversation(7, "")
+	if conv.UserID != 7 || conv.Topic != "Tabs or spaces?" || conv.Scenario != "debate" || conv.ScenarioVersion != 2 {
+		t.Fatalf("conversation = %+v", conv)
+	}
+	if conv.Judge.Panel[1].Model != "other-model" {
+		t.Fatalf("panel not copied: %+v", conv.Judge.Panel)
+	}
+	// Resolving the conversation must not change the loaded scenario.
+	conv.Agents[0].Prompt = "changed"
+	if strings.Contains(debate.Participants[0].Prompt, "changed") {
+		t.Fatal("conversation shares participants with the scenario")
+	}
+}
+
+func TestParseRejectsInvalidSetup(t *testing.T) {
+	_, err := Parse("bad.yaml", []byte("version: 1\nparticipants: [{name: A}]\n"))
+	if err == nil || !strings.Contains(err.Error(), "role or a prompt") {
+		t.Fatalf("err = %v, want missing prompt", err)
+	}
+	_, err = Parse("bad.yaml", []byte("version: 1\nturnPolicy: chaos\nparticipants: [{name: A, prompt: p}]\n"))
+	if err == nil {
+		t.Fatal("expected unknown turn policy to be rejected")
+	}
+}
+
+func TestExampleScenarios(t *testing.T) {
+	scenarios, problems, err := LoadDir("../../scenarios")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) > 0 {
+		t.Fatalf("example scenarios have problems: %+v", problems)
+	}
+	if len(scenarios) == 0 {
+		t.Fatal("no example scenarios found")
+	}
+}
@@ -26,6 +26,10 @@ func main() {
 	// Seed Defaults
 	data.SeedRoles()
 
+	if dir := os.Getenv("SCENARIO_DIR"); dir != "" {
+		api.ScenarioDir = dir
+	}
+
 	r := gin.Default()
 
 	// CORS
@@ -56,12 +60,29 @@ func main() {
 		auth.GET("/conversations", api.GetConversations)
 		auth.POST("/conversations", api.CreateConversation)
 		auth.GET("/conversations/:id", api.GetConversation)
+		auth.PUT("/conversations/:id/agents", api.UpdateConversationAgents)
+		auth.POST("/conversations/:id/fork", api.ForkConversation)
+		auth.GET("/conversations/:id/forks", api.GetForks)
+		auth.POST("/conversations/:id/regenerate", api.RegenerateMessage)
+		auth.PUT("/conversations/:id/messages/:msgId", api.EditMessage)
+		auth.DELETE("/conversations/:id/messages/:msgId", api.DeleteMessage)
+		auth.POST("/conversations/:id/pause", api.PauseConversation)
+		auth.POST("/conversations/:id/resume", api.ResumeConversation)
+		auth.POST("/conversations/:id/stop", api.StopConversation)
+		auth.POST("/conversations/:id/conclude", api.ConcludeConversation)
 		auth.DELETE("/conversations/:id", api.DeleteConversation)
 
+		auth.GET("/scenarios", api.GetScenarios)
+		auth.POST("/scenarios/:name/start", api.StartScenario)
+
 		auth.GET("/roles", api.GetRoles)
 		auth.POST("/roles", api.CreateRole)
 		auth.DELETE("/roles/:name", api.DeleteRole)
 
+		auth.GET("/profiles", api.GetProfiles)
+		auth.POST("/profiles", api.SaveProfile)
+		auth.DELETE("/profiles/:name", api.DeleteProfile)
+
 		auth.GET("/config", api.GetConfig)
 		auth.POST("/config", api.UpdateConfig)
 	}